@@ -39,10 +39,8 @@ function addPost () {
         imprintChain()
         document.getElementById('add-modal-tr').textContent = 'Show passcode'
       } else if (resp.marker === 2) {
-        const newCode = unlockRawPasscode(
-          resp.data,
-          CryptoJS.SHA256('gen6si9').toString()
-        )
+        // Genesis passcode is the token issued by the admin
+        const newCode = unlockRawPasscode(resp.data, hash)
         responseBox.textContent = `${resp.message}. 
                 The next passcode is ${newCode}`
 
@@ -152,9 +150,11 @@ function selectDesc (descriptor) {
   SelectedDescriptor = descriptor
 }
 
-/* Gets latest raw chain data from backend */
+/* Gets latest raw chain data from backend, for the chain in the page url */
 const getChainHtml = async () => {
-  const posts = await fetch('/html/chain', { method: 'GET' })
+  const chain = new URLSearchParams(window.location.search).get('chain')
+  const query = chain ? `?chain=${encodeURIComponent(chain)}` : ''
+  const posts = await fetch(`/html/chain${query}`, { method: 'GET' })
   return await posts.text()
 }
 
@@ -194,7 +194,8 @@ const imprintChain = () => {
         document.getElementById('deck').innerHTML = content
       } else {
         document.getElementById('deck').innerHTML = `<h2 class="text-lg
-            text-white">Go make the first post! No passcode is required.</h2>`
+            text-white">Go make the first post! Use the genesis token from your
+            admin as the passcode.</h2>`
       }
     })
     .catch((err) => {
@@ -1,13 +1,54 @@
 package config
 
-import "os"
+import (
+	"os"
+	"strings"
+)
 
 var (
-	DB_FILEPATH      string
-	AES_IV           string // must be of length 32
-	AES_SPLICE_INDEX string // must be a string parsable to >=0 and <= 31
+	DB_FILEPATH       string
+	AES_IV            string // must be of length 32
+	AES_SPLICE_INDEX  string // must be a string parsable to >=0 and <= 31
+	ADMIN_KEY         string // required to access admin routes. Secret
+	LOCKOUT_THRESHOLD string // failed attempts in a day before lockout begins
+	ALLOWED_ORIGINS   string // comma separated, same origin is always allowed
+	ALLOWED_METHODS   string // comma separated methods allowed cross origin
+	CSP               string // content security policy, suited to the client
+	HSTS_MAX_AGE      string // seconds, only sent over https. 0 disables
+	REFERRER_POLICY   string // sent with every response
+	HTTP_ADDR         string // redirects to https when tls is configured
+	HTTPS_ADDR        string
+	TLS_CERT_FILE     string // empty serves plain http, reloaded on SIGHUP
+	TLS_KEY_FILE      string
+	TLS_MIN_VERSION   string // either 1.2 or 1.3
+	TLS_CIPHERS       string // comma separated, in order. Empty uses defaults
+	SESSION_KEYS      string // 'id:secret,id:secret', first signs. Secret
+	SESSION_TTL       string // minutes that a session token is valid for
+	ROTATION_LIMIT    string // rotations allowed of one passcode in a day
+	POST_EXPIRY       string // days a passcode can post for. 0 never expires
+	REACT_EXPIRY      string // days a passcode can react for. 0 never expires
+	CHAIN_SECRET      string // makes the chain private. Empty is public
+	ERASE_REACTIONS   string // either keep or drop reactions of erased posts
+	REVIVAL_DAYS      string // days dormant before anyone can revive. 0 never
+	REVIVAL_WORK      string // leading zero bits of a revival proof of work
+	CANDIDATE_WINDOW  string // passcodes that can post, from head to genesis
+	WORD_LIST         string // list the original chain picks descriptors from
+	WORD_LIST_DIR     string // .txt word lists to load. Empty loads none
+	WORD_ALLOWLIST    string // file of the only words allowed. Empty allows all
+	WORD_BLOCKLIST    string // file of words never used, beyond the defaults
+	WORD_MEMORY       string // latest posts whose descriptors are avoided
+	DESCRIPTOR_MODE   string // either content to score words, or random
+	STATUS_LIMIT      string // status requests a client can make each minute
 )
 
+// Allows the client under /w its stylesheets, fonts and crypto library
+const clientCsp = "default-src 'self'; " +
+	"script-src 'self' 'unsafe-inline' https://cdnjs.cloudflare.com; " +
+	"style-src 'self' 'unsafe-inline' https://fonts.googleapis.com; " +
+	"font-src 'self' https://fonts.gstatic.com; img-src 'self' data:; " +
+	"connect-src 'self'; object-src 'none'; base-uri 'self'; " +
+	"frame-ancestors 'none'"
+
 /* Sets environment variables ued by program. Will be different for integration
 tests than in production */
 func SetupEnv(isProduction bool) {
@@ -15,12 +56,129 @@ func SetupEnv(isProduction bool) {
 		DB_FILEPATH = "./data/blog.db"
 		AES_IV = "snooping6is9bad0"
 		AES_SPLICE_INDEX = "28"
+		ADMIN_KEY = readSecret("ADMIN_KEY")
+		LOCKOUT_THRESHOLD = "5"
+		ALLOWED_ORIGINS = ""
+		ALLOWED_METHODS = "GET,POST,HEAD,OPTIONS"
+		CSP = clientCsp
+		HSTS_MAX_AGE = "31536000"
+		REFERRER_POLICY = "same-origin"
+		HTTP_ADDR = ":8007"
+		HTTPS_ADDR = ":8443"
+		TLS_CERT_FILE = readSetting("TLS_CERT_FILE", "")
+		TLS_KEY_FILE = readSetting("TLS_KEY_FILE", "")
+		TLS_MIN_VERSION = "1.2"
+		TLS_CIPHERS = ""
+		SESSION_KEYS = readSecret("SESSION_KEYS")
+		SESSION_TTL = "30"
+		ROTATION_LIMIT = "3"
+		POST_EXPIRY = "90"
+		REACT_EXPIRY = "365"
+		CHAIN_SECRET = readSecret("CHAIN_SECRET")
+		ERASE_REACTIONS = "keep"
+		REVIVAL_DAYS = "30"
+		REVIVAL_WORK = "20"
+		CANDIDATE_WINDOW = "5"
+		WORD_LIST = readSetting("WORD_LIST", "adjectives")
+		WORD_LIST_DIR = readSetting("WORD_LIST_DIR", "")
+		WORD_ALLOWLIST = readSetting("WORD_ALLOWLIST", "")
+		WORD_BLOCKLIST = readSetting("WORD_BLOCKLIST", "")
+		WORD_MEMORY = readSetting("WORD_MEMORY", "3")
+		DESCRIPTOR_MODE = readSetting("DESCRIPTOR_MODE", "content")
+		STATUS_LIMIT = "20"
 	} else {
 		DB_FILEPATH = "./data/blog_test.db"
 		AES_IV = "snooping6is9bad0"
 		AES_SPLICE_INDEX = "28"
+		ADMIN_KEY = "test6admin9key0"
+		LOCKOUT_THRESHOLD = "50"
+		ALLOWED_ORIGINS = "http://localhost:3000"
+		ALLOWED_METHODS = "GET,POST,HEAD,OPTIONS"
+		CSP = clientCsp
+		HSTS_MAX_AGE = "0"
+		REFERRER_POLICY = "same-origin"
+		HTTP_ADDR = ":8007"
+		HTTPS_ADDR = ":8443"
+		TLS_CERT_FILE = ""
+		TLS_KEY_FILE = ""
+		TLS_MIN_VERSION = "1.2"
+		TLS_CIPHERS = ""
+		SESSION_KEYS = "k1:test6session9key0"
+		SESSION_TTL = "30"
+		ROTATION_LIMIT = "2"
+		POST_EXPIRY = "90"
+		REACT_EXPIRY = "365"
+		CHAIN_SECRET = ""
+		ERASE_REACTIONS = "drop"
+		REVIVAL_DAYS = "30"
+		REVIVAL_WORK = "8"
+		CANDIDATE_WINDOW = "5"
+		WORD_LIST = "adjectives"
+		WORD_LIST_DIR = ""
+		WORD_ALLOWLIST = ""
+		WORD_BLOCKLIST = ""
+		WORD_MEMORY = "3"
+		DESCRIPTOR_MODE = "content"
+		STATUS_LIMIT = "20"
 	}
 	os.Setenv("DB_FILEPATH", DB_FILEPATH)
 	os.Setenv("AES_IV", AES_IV)
 	os.Setenv("AES_SPLICE_INDEX", AES_SPLICE_INDEX)
+	os.Setenv("ADMIN_KEY", ADMIN_KEY)
+	os.Setenv("LOCKOUT_THRESHOLD", LOCKOUT_THRESHOLD)
+	os.Setenv("ALLOWED_ORIGINS", ALLOWED_ORIGINS)
+	os.Setenv("ALLOWED_METHODS", ALLOWED_METHODS)
+	os.Setenv("CSP", CSP)
+	os.Setenv("HSTS_MAX_AGE", HSTS_MAX_AGE)
+	os.Setenv("REFERRER_POLICY", REFERRER_POLICY)
+	os.Setenv("HTTP_ADDR", HTTP_ADDR)
+	os.Setenv("HTTPS_ADDR", HTTPS_ADDR)
+	os.Setenv("TLS_CERT_FILE", TLS_CERT_FILE)
+	os.Setenv("TLS_KEY_FILE", TLS_KEY_FILE)
+	os.Setenv("TLS_MIN_VERSION", TLS_MIN_VERSION)
+	os.Setenv("TLS_CIPHERS", TLS_CIPHERS)
+	os.Setenv("SESSION_KEYS", SESSION_KEYS)
+	os.Setenv("SESSION_TTL", SESSION_TTL)
+	os.Setenv("ROTATION_LIMIT", ROTATION_LIMIT)
+	os.Setenv("POST_EXPIRY", POST_EXPIRY)
+	os.Setenv("REACT_EXPIRY", REACT_EXPIRY)
+	os.Setenv("CHAIN_SECRET", CHAIN_SECRET)
+	os.Setenv("ERASE_REACTIONS", ERASE_REACTIONS)
+	os.Setenv("REVIVAL_DAYS", REVIVAL_DAYS)
+	os.Setenv("REVIVAL_WORK", REVIVAL_WORK)
+	os.Setenv("CANDIDATE_WINDOW", CANDIDATE_WINDOW)
+	os.Setenv("WORD_LIST", WORD_LIST)
+	os.Setenv("WORD_LIST_DIR", WORD_LIST_DIR)
+	os.Setenv("WORD_ALLOWLIST", WORD_ALLOWLIST)
+	os.Setenv("WORD_BLOCKLIST", WORD_BLOCKLIST)
+	os.Setenv("WORD_MEMORY", WORD_MEMORY)
+	os.Setenv("DESCRIPTOR_MODE", DESCRIPTOR_MODE)
+	os.Setenv("STATUS_LIMIT", STATUS_LIMIT)
+}
+
+/* Reads a setting from the environment variable *name*, so that operators can
+set it without editing the source. Gives *fallback* when it is unset */
+func readSetting(name, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
+
+/* Reads a secret from the environment variable *name*, or otherwise from the
+file named by *name*_FILE, so that secrets are never kept in the source. Gives
+an empty string when neither is set */
+func readSecret(name string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
 }
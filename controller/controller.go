@@ -2,10 +2,13 @@ package controller
 
 import (
 	"database/sql"
+	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	tp "github.com/georgejmx/whisper-blog/types"
+	u "github.com/georgejmx/whisper-blog/utils"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -15,6 +18,39 @@ type DbController struct {
 	db *sql.DB
 }
 
+// A column added to a table after its creation
+type columnMigration struct {
+	table      string
+	column     string
+	definition string
+}
+
+// Columns that databases created by older versions are missing
+var columnMigrations = []columnMigration{
+	{"Post", "reveal", "datetime"},
+	{"Post", "passcodeId", "integer"},
+	{"Post", "erased", "integer not null default 0"},
+	{"Post", "hashIndex", "integer"},
+	{"Post", "role", "varchar(10)"},
+	{"Post", "branch", "integer not null default 0"},
+	{"Post", "parentId", "integer"},
+	{"Post", "revival", "varchar(10)"},
+	{"Post", "wordList", "varchar(40)"},
+	{"Fork", "wordList", "varchar(40)"},
+	{"Reaction", "descriptorId", "integer"},
+	{"Passcode", "issued", "datetime"},
+	{"Passcode", "consumed", "datetime"},
+	{"Passcode", "postExpires", "datetime"},
+	{"Passcode", "reactExpires", "datetime"},
+	{"Passcode", "retired", "integer not null default 0"},
+	{"Passcode", "note", "varchar(1100)"},
+	{"Passcode", "branch", "integer not null default 0"},
+	{"Passcode", "postId", "integer"},
+}
+
+// Format that sqlite stores its current_timestamp in, used for comparisons
+const TIME_FORMAT = "2006-01-02 15:04:05"
+
 /* Establishes database connection and sets up tables if needed
 Returns an error if applicable */
 func (dbo *DbController) Init() error {
@@ -30,7 +66,7 @@ func (dbo *DbController) Init() error {
 	dbo.db.SetMaxIdleConns(10)
 
 	// Define database tables
-	queries := [3]string{
+	queries := [11]string{
 		`create table if not exists Post (
 			id integer primary key autoincrement not null,
 			title varchar(40) not null unique,
@@ -39,11 +75,28 @@ func (dbo *DbController) Init() error {
 			tag integer not null,
 			descriptors varchar(210),
 			time datetime default current_timestamp,
+			reveal datetime,
+			passcodeId integer,
+			erased integer not null default 0,
+			hashIndex integer,
+			role varchar(10),
+			branch integer not null default 0,
+			parentId integer,
+			revival varchar(10),
+			wordList varchar(40),
 			check (tag >= 0 and tag < 8)
 		)`,
 		`create table if not exists Passcode (
 			id integer primary key autoincrement not null,
-			hash varchar(64) not null
+			hash varchar(64) not null,
+			issued datetime default current_timestamp,
+			consumed datetime,
+			postExpires datetime,
+			reactExpires datetime,
+			retired integer not null default 0,
+			note varchar(1100),
+			branch integer not null default 0,
+			postId integer
 		)`,
 		`create table if not exists Reaction (
 			id integer primary key autoincrement not null,
@@ -51,9 +104,68 @@ func (dbo *DbController) Init() error {
 			descriptor varchar(20) not null,
 			gravitas integer not null,
 			gravitasHash varchar(64),
+			descriptorId integer,
 			foreign key(postId) references Post(id),
+			foreign key(descriptorId) references PostDescriptor(id),
 			check (gravitas <= 6)
 		)`,
+		`create table if not exists PostDescriptor (
+			id integer primary key autoincrement not null,
+			postId integer not null,
+			position integer not null,
+			word varchar(40) not null,
+			foreign key(postId) references Post(id),
+			unique (postId, position)
+		)`,
+		`create table if not exists SecurityEvent (
+			id integer primary key autoincrement not null,
+			kind varchar(20) not null,
+			reason varchar(100),
+			clientHash varchar(64) not null,
+			time datetime default current_timestamp
+		)`,
+		`create table if not exists PasscodeRotation (
+			id integer primary key autoincrement not null,
+			passcodeId integer not null,
+			clientHash varchar(64) not null,
+			time datetime default current_timestamp,
+			foreign key(passcodeId) references Passcode(id)
+		)`,
+		`create table if not exists GenesisToken (
+			id integer primary key autoincrement not null,
+			hash varchar(64) not null unique,
+			used integer not null default 0,
+			time datetime default current_timestamp
+		)`,
+		`create table if not exists ReadToken (
+			id integer primary key autoincrement not null,
+			hash varchar(64) not null unique,
+			revoked integer not null default 0,
+			time datetime default current_timestamp
+		)`,
+		`create table if not exists Forfeit (
+			id integer primary key autoincrement not null,
+			passcodeId integer not null unique,
+			postId integer,
+			time datetime default current_timestamp,
+			foreign key(passcodeId) references Passcode(id),
+			foreign key(postId) references Post(id)
+		)`,
+		`create table if not exists Fork (
+			id integer primary key autoincrement not null,
+			sourcePostId integer not null,
+			branch integer not null unique,
+			authority varchar(10) not null,
+			time datetime default current_timestamp,
+			wordList varchar(40),
+			foreign key(sourcePostId) references Post(id)
+		)`,
+		`create table if not exists RevivalToken (
+			id integer primary key autoincrement not null,
+			hash varchar(64) not null unique,
+			used integer not null default 0,
+			time datetime default current_timestamp
+		)`,
 	}
 
 	// Execute all table creation on database
@@ -65,16 +177,142 @@ func (dbo *DbController) Init() error {
 			return err
 		}
 	}
+
+	// Bring tables created by older versions up to date
+	for _, migration := range columnMigrations {
+		if err = addColumnIfMissing(tx, migration); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	_, err = tx.Exec(`update Passcode set issued = current_timestamp
+		where issued is null`)
+	if err == nil {
+		err = migrateDescriptors(tx)
+	}
+	if err == nil {
+		err = migrateRoles(tx)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 	return tx.Commit()
 }
 
+/* Moves the descriptors strings of posts from older versions into a row per
+descriptor, then links each of their reactions to the descriptor it names
+exactly. Reactions to words that were only ever substrings are left unlinked */
+func migrateDescriptors(tx *sql.Tx) error {
+	rows, err := tx.Query(`select id, descriptors from Post
+		where descriptors is not null and descriptors != ''`)
+	if err != nil {
+		return err
+	}
+	legacy := make(map[int]string)
+	for rows.Next() {
+		var (
+			postId      int
+			descriptors string
+		)
+		if err = rows.Scan(&postId, &descriptors); err != nil {
+			rows.Close()
+			return err
+		}
+		legacy[postId] = descriptors
+	}
+	rows.Close()
+
+	for postId, descriptors := range legacy {
+		if err = insertDescriptors(tx, postId,
+			strings.Split(descriptors, ";")); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`update Post set descriptors = null where id = ?`,
+			postId)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`update Reaction set descriptorId = (select id from
+		PostDescriptor d where d.postId = Reaction.postId and
+		d.word = Reaction.descriptor order by position limit 1)
+		where descriptorId is null`)
+	return err
+}
+
+/* Records the candidate role of posts from older versions, which only have
+their candidate index, using the candidate window at the time of upgrading.
+Roles are then kept as they were, even if the window changes */
+func migrateRoles(tx *sql.Tx) error {
+	_, err := tx.Exec(`update Post set role = case hashIndex
+		when 0 then ? when ? then ? else ? end
+		where role is null and hashIndex is not null`, tp.ROLE_HEAD,
+		u.GenesisIndex(), tp.ROLE_GENESIS, tp.ROLE_RECENT)
+	return err
+}
+
+/* Inserts a row for each descriptor of a post, in order. Empty words are
+skipped */
+func insertDescriptors(tx *sql.Tx, postId int, words []string) error {
+	position := 0
+	for _, word := range words {
+		if word == "" {
+			continue
+		}
+		_, err := tx.Exec(`insert into PostDescriptor (postId, position, word)
+			values (?, ?, ?)`, postId, position, word)
+		if err != nil {
+			return err
+		}
+		position++
+	}
+	return nil
+}
+
+/* Adds a column to an existing table if it is not already there. Columns added
+this way cannot have a non-constant default */
+func addColumnIfMissing(tx *sql.Tx, migration columnMigration) error {
+	rows, err := tx.Query(fmt.Sprintf(`pragma table_info(%s)`, migration.table))
+	if err != nil {
+		return err
+	}
+
+	// Finding the column by name, the second field of table_info
+	isPresent := false
+	columns, _ := rows.Columns()
+	values := make([]interface{}, len(columns))
+	for rows.Next() {
+		var name string
+		for i := range values {
+			values[i] = new(interface{})
+		}
+		values[1] = &name
+		if err = rows.Scan(values...); err != nil {
+			rows.Close()
+			return err
+		}
+		isPresent = isPresent || name == migration.column
+	}
+	rows.Close()
+
+	if isPresent {
+		return nil
+	}
+	_, err = tx.Exec(fmt.Sprintf(`alter table %s add column %s %s`,
+		migration.table, migration.column, migration.definition))
+	return err
+}
+
 /* Gets all Post tuples from sqlite */
 func (dbo *DbController) SelectPosts() ([]tp.Post, error) {
 	var posts []tp.Post
 	tx, _ := dbo.db.Begin()
 
 	// Getting rows from query
-	rows, err := tx.Query(`select * from Post order by id desc`)
+	rows, err := tx.Query(`select id, title, author, contents, tag, time,
+		reveal, passcodeId, erased, hashIndex, role, branch, parentId, revival
+		from Post order by id desc`)
 	if err != nil {
 		tx.Rollback()
 		return posts, err
@@ -82,15 +320,57 @@ func (dbo *DbController) SelectPosts() ([]tp.Post, error) {
 
 	// Adding post rows from database table to the posts variable, unless error
 	for rows.Next() {
-		var post tp.Post
+		var (
+			post                            tp.Post
+			reveal                          sql.NullTime
+			passcodeId, hashIndex, parentId sql.NullInt64
+			role, revival                   sql.NullString
+		)
 		if err = rows.Scan(&post.Id, &post.Title, &post.Author, &post.Contents,
-			&post.Tag, &post.Descriptors, &post.Time); err != nil {
+			&post.Tag, &post.Time, &reveal, &passcodeId, &post.Erased,
+			&hashIndex, &role, &post.Branch, &parentId,
+			&revival); err != nil {
 			return posts, err
 		}
+		if reveal.Valid {
+			post.Reveal = &reveal.Time
+		}
+		post.PasscodeId = int(passcodeId.Int64)
+		post.ParentId = int(parentId.Int64)
+		post.Role = role.String
+		post.Revival = revival.String
+
+		// Posts with no recorded index, such as the genesis post, are -1
+		post.HashIndex = -1
+		if hashIndex.Valid {
+			post.HashIndex = int(hashIndex.Int64)
+		}
 		posts = append(posts, post)
 	}
+	rows.Close()
 
+	// Joining the descriptors of each post back together, in order
+	rows, err = tx.Query(`select postId, word from PostDescriptor
+		order by postId, position`)
+	if err != nil {
+		tx.Rollback()
+		return posts, err
+	}
+	words := make(map[int][]string)
+	for rows.Next() {
+		var (
+			postId int
+			word   string
+		)
+		if err = rows.Scan(&postId, &word); err != nil {
+			return posts, err
+		}
+		words[postId] = append(words[postId], word)
+	}
 	rows.Close()
+	for i := range posts {
+		posts[i].Descriptors = strings.Join(words[posts[i].Id], ";")
+	}
 	return posts, tx.Commit()
 }
 
@@ -123,13 +403,35 @@ func (dbo *DbController) SelectPostReactions(
 	return reactions, tx.Commit()
 }
 
-/* Gets the timestamp of the latest post */
-func (dbo *DbController) SelectLatestTimestamp() (time.Time, error) {
+/* Gets the timestamp of the post that issued the latest passcode of a branch.
+Passcodes from older versions have no issuing post, so the latest post of the
+branch is used for them. A fork with no posts yet uses the time it was made,
+as its genesis passcode is recorded against a source post that may be old */
+func (dbo *DbController) SelectLatestTimestamp(branch int) (time.Time, error) {
 	var timestamp time.Time
 
 	tx, _ := dbo.db.Begin()
-	row, err := tx.Query(`select time from Post where id = 
-		(select max(id) from Post)`)
+	row, err := tx.Query(`select time from Fork where branch = ? and not exists
+		(select id from Post where branch = ?)`, branch, branch)
+	if err != nil {
+		tx.Rollback()
+		return timestamp, err
+	}
+	if row.Next() {
+		err = row.Scan(&timestamp)
+		row.Close()
+		if err != nil {
+			tx.Rollback()
+			return timestamp, err
+		}
+		return timestamp, tx.Commit()
+	}
+	row.Close()
+
+	row, err = tx.Query(`select time from Post where id = coalesce(
+		(select postId from Passcode where branch = ? and postId is not null
+			order by id desc limit 1),
+		(select max(id) from Post where branch = ?))`, branch, branch)
 	if err != nil {
 		tx.Rollback()
 		return timestamp, err
@@ -144,12 +446,177 @@ func (dbo *DbController) SelectLatestTimestamp() (time.Time, error) {
 /* Adds a new post to the blog database. Using user data from the frontend
 and a generated descriptors and tag. // Params: Post with the fields title,
 author, contents, descriptors, tag, codeHash populated //
-Ensures that all data for a post has been entered */
-func (dbo *DbController) InsertPost(post tp.Post) error {
+Ensures that all data for a post has been entered. Returns the id of the post,
+which the passcodes it issues are recorded against */
+func (dbo *DbController) InsertPost(post tp.Post) (int, error) {
+	tx, _ := dbo.db.Begin()
+	postId, err := insertPost(tx, post)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return postId, tx.Commit()
+}
+
+/* Inserts the genesis post, consuming the unused genesis token with the
+provided hash in the same transaction. Returns the id of the post, which is
+zero when there was no such token, so the token is only used up once the post
+has been stored */
+func (dbo *DbController) InsertGenesisPost(
+	post tp.Post, tokenHash string) (int, error) {
+	return dbo.insertTokenPost("GenesisToken", post, tokenHash)
+}
+
+/* Inserts a revival post, consuming the unused revival token with the
+provided hash in the same transaction, the same as *InsertGenesisPost* */
+func (dbo *DbController) InsertRevivalPost(
+	post tp.Post, tokenHash string) (int, error) {
+	return dbo.insertTokenPost("RevivalToken", post, tokenHash)
+}
+
+/* Marks the unused token with the provided hash in the token table as used,
+then inserts the post, all in one transaction. Returns the id of the post, or
+zero when there was no such token */
+func (dbo *DbController) insertTokenPost(
+	table string, post tp.Post, tokenHash string) (int, error) {
+	tx, _ := dbo.db.Begin()
+	result, err := tx.Exec(fmt.Sprintf(`update %s set used = 1 where hash = ?
+		and used = 0`, table), tokenHash)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil || affected != 1 {
+		tx.Rollback()
+		return 0, err
+	}
+	postId, err := insertPost(tx, post)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return postId, tx.Commit()
+}
+
+/* Inserts a post and its descriptors as part of a transaction, returning the
+id of the post */
+func insertPost(tx *sql.Tx, post tp.Post) (int, error) {
+	var (
+		reveal, passcodeId, hashIndex, role interface{}
+		parentId, revival, wordList         interface{}
+	)
+	if post.Reveal != nil {
+		reveal = nullableTime(*post.Reveal)
+	}
+	if post.PasscodeId != 0 {
+		passcodeId = post.PasscodeId
+	}
+	if post.HashIndex >= 0 {
+		hashIndex = post.HashIndex
+	}
+	if post.Role != "" {
+		role = post.Role
+	}
+	if post.ParentId != 0 {
+		parentId = post.ParentId
+	}
+	if post.Revival != "" {
+		revival = post.Revival
+	}
+	if post.WordList != "" {
+		wordList = post.WordList
+	}
+
+	result, err := tx.Exec(`insert into Post (title, author, contents, tag,
+		reveal, passcodeId, hashIndex, role, branch, parentId, revival,
+		wordList) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, post.Title,
+		post.Author, post.Contents, post.Tag, reveal, passcodeId, hashIndex,
+		role, post.Branch, parentId, revival, wordList)
+	if err != nil {
+		return 0, err
+	}
+
+	// Storing each descriptor as its own row
+	postId, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(postId), insertDescriptors(tx, int(postId),
+		strings.Split(post.Descriptors, ";"))
+}
+
+/* Selects the time that a post is revealed, which is zero if it was never
+sealed */
+func (dbo *DbController) SelectPostReveal(postId int) (time.Time, error) {
+	var reveal sql.NullTime
+
+	tx, _ := dbo.db.Begin()
+	row, err := tx.Query(`select reveal from Post where id = ?`, postId)
+	if err != nil {
+		tx.Rollback()
+		return reveal.Time, err
+	}
+	if !row.Next() {
+		row.Close()
+		tx.Rollback()
+		return reveal.Time, sql.ErrNoRows
+	}
+	if err = row.Scan(&reveal); err != nil {
+		return reveal.Time, err
+	}
+	row.Close()
+	return reveal.Time, tx.Commit()
+}
+
+/* Selects the id of the passcode that authorised a post, which is zero for
+posts with no recorded passcode such as the genesis post */
+func (dbo *DbController) SelectPostPasscodeId(postId int) (int, error) {
+	var passcodeId sql.NullInt64
+
+	tx, _ := dbo.db.Begin()
+	row, err := tx.Query(`select passcodeId from Post where id = ?`, postId)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if !row.Next() {
+		row.Close()
+		tx.Rollback()
+		return 0, sql.ErrNoRows
+	}
+	if err = row.Scan(&passcodeId); err != nil {
+		return 0, err
+	}
+	row.Close()
+	return int(passcodeId.Int64), tx.Commit()
+}
+
+/* Replaces the title, contents and author of a post with a tombstone, keeping
+its position and time in the chain. Its descriptors are deleted, and its
+reactions too if requested, otherwise they no longer point at a descriptor */
+func (dbo *DbController) ErasePost(
+	postId int, title, contents string, dropReactions bool) error {
 	tx, _ := dbo.db.Begin()
-	_, err := tx.Exec(`insert into Post (title, author, contents, descriptors, 
-		tag) values (?, ?, ?, ?, ?)`,
-		post.Title, post.Author, post.Contents, post.Descriptors, post.Tag)
+	_, err := tx.Exec(`update Post set title = ?, contents = ?, author = '',
+		erased = 1 where id = ?`, title, contents, postId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if dropReactions {
+		_, err = tx.Exec(`delete from Reaction where postId = ?`, postId)
+	} else {
+		_, err = tx.Exec(`update Reaction set descriptorId = null
+			where postId = ?`, postId)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Descriptors are scored against the contents, so would give them away
+	_, err = tx.Exec(`delete from PostDescriptor where postId = ?`, postId)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -161,8 +628,9 @@ func (dbo *DbController) InsertPost(post tp.Post) error {
 func (dbo *DbController) InsertReaction(reaction tp.Reaction) error {
 	tx, _ := dbo.db.Begin()
 	_, err := tx.Exec(`insert into Reaction (postId, descriptor, gravitas,
-		gravitasHash) values (?, ?, ?, ?)`, reaction.PostId,
-		reaction.Descriptor, reaction.Gravitas, reaction.GravitasHash)
+		gravitasHash, descriptorId) values (?, ?, ?, ?, ?)`, reaction.PostId,
+		reaction.Descriptor, reaction.Gravitas, reaction.GravitasHash,
+		reaction.DescriptorId)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -170,22 +638,26 @@ func (dbo *DbController) InsertReaction(reaction tp.Reaction) error {
 	return tx.Commit()
 }
 
-/* Selects the 5 hashes that can be used for post or reaction validation. This
-is an array of the form [latest hash, second latest hash, third latest,
-fourth latest, genesis hash] */
-func (dbo *DbController) SelectCandidateHashes() ([5]string, error) {
-	hashes := [5]string{"", "", "", "", ""}
+/* Selects the *n* hashes that can be used for post or reaction validation on
+a branch. This is a slice of the form [latest hash, second latest hash, ...,
+genesis hash], where the genesis hash is the first passcode issued on the
+branch and is always last. Slots of branches with fewer passcodes are left
+empty */
+func (dbo *DbController) SelectCandidateHashes(
+	branch, n int) ([]string, error) {
+	hashes := make([]string, n)
 	tx, _ := dbo.db.Begin()
 
-	// Selecting the most recent 4 hashes with such query, then parsing
+	// Selecting the most recent n - 1 hashes with such query, then parsing
 	topRows, err := tx.Query(
-		`select hash from Passcode order by id desc limit 4`)
+		`select hash from Passcode where branch = ? order by id desc limit ?`,
+		branch, n-1)
 	if err != nil {
 		tx.Rollback()
 		return hashes, err
 	}
 	i := 0
-	for topRows.Next() && i < 4 {
+	for topRows.Next() && i < n-1 {
 		if err = topRows.Scan(&hashes[i]); err != nil {
 			return hashes, err
 		}
@@ -193,24 +665,26 @@ func (dbo *DbController) SelectCandidateHashes() ([5]string, error) {
 	}
 	topRows.Close()
 
-	// Selecting the genesis row, then returning the complete array
-	genesisRow, err := tx.Query(
-		`select hash from Passcode order by id asc limit 1`)
+	// Selecting the genesis row of the branch, then returning the complete
+	// slice
+	genesisRow, err := tx.Query(`select hash from Passcode where branch = ?
+		order by id asc limit 1`, branch)
 	if err != nil {
 		tx.Rollback()
 		return hashes, err
 	}
 	genesisRow.Next()
-	if err = genesisRow.Scan(&hashes[4]); err != nil {
+	if err = genesisRow.Scan(&hashes[n-1]); err != nil {
 		return hashes, err
 	}
 	return hashes, tx.Commit()
 }
 
-/* Selects the reaction hashes associated with a given post */
+/* Selects every distinct hash that has made an attributed reaction to a given
+post */
 func (dbo *DbController) SelectPostReactionHashes(
-	postId int) ([5]string, error) {
-	reactionHashes := [5]string{"", "", "", "", ""}
+	postId int) ([]string, error) {
+	var reactionHashes []string
 	tx, _ := dbo.db.Begin()
 
 	// Selecting all such hashes
@@ -220,33 +694,90 @@ func (dbo *DbController) SelectPostReactionHashes(
 		tx.Rollback()
 		return reactionHashes, err
 	}
-	i := 0
-	for rows.Next() && i < 5 {
-		var reactionHash string
+	for rows.Next() {
+		var reactionHash sql.NullString
 		if err := rows.Scan(&reactionHash); err != nil {
 			return reactionHashes, err
 		}
-		reactionHashes[i] = reactionHash
-		i++
+		if reactionHash.String != "" {
+			reactionHashes = append(reactionHashes, reactionHash.String)
+		}
 	}
 	rows.Close()
 	return reactionHashes, tx.Commit()
 }
 
-/* Selects the descriptors string from the post with id *postId* */
-func (dbo *DbController) SelectDescriptors(postId int) (string, error) {
-	var descriptors string
+/* Selects the ids of the posts that the passcode hash has reacted to, newest
+first */
+func (dbo *DbController) SelectReactedPostIds(hash string) ([]int, error) {
+	var postIds []int
+	tx, _ := dbo.db.Begin()
+
+	rows, err := tx.Query(`select distinct postId from Reaction where
+		gravitasHash = ? order by postId desc`, hash)
+	if err != nil {
+		tx.Rollback()
+		return postIds, err
+	}
+	for rows.Next() {
+		var postId int
+		if err := rows.Scan(&postId); err != nil {
+			return postIds, err
+		}
+		postIds = append(postIds, postId)
+	}
+	rows.Close()
+	return postIds, tx.Commit()
+}
 
+/* Selects the descriptors of the post with id *postId*, in order */
+func (dbo *DbController) SelectPostDescriptors(
+	postId int) ([]tp.Descriptor, error) {
+	var descriptors []tp.Descriptor
 	tx, _ := dbo.db.Begin()
-	row, err := tx.Query(`select descriptors from Post where id = ?`, postId)
+
+	rows, err := tx.Query(`select id, postId, position, word
+		from PostDescriptor where postId = ? order by position`, postId)
 	if err != nil {
 		tx.Rollback()
 		return descriptors, err
 	}
-	row.Next()
-	if err = row.Scan(&descriptors); err != nil {
+	for rows.Next() {
+		var descriptor tp.Descriptor
+		if err = rows.Scan(&descriptor.Id, &descriptor.PostId,
+			&descriptor.Position, &descriptor.Word); err != nil {
+			return descriptors, err
+		}
+		descriptors = append(descriptors, descriptor)
+	}
+	rows.Close()
+	return descriptors, tx.Commit()
+}
+
+/* Selects the descriptors of the latest *limit* posts, newest first, so that
+new posts can avoid them. Posts of every branch and fork are counted, so
+words recently used anywhere on the blog are avoided */
+func (dbo *DbController) SelectRecentDescriptors(limit int) ([]string, error) {
+	var descriptors []string
+	tx, _ := dbo.db.Begin()
+
+	rows, err := tx.Query(`select word from PostDescriptor where postId in
+		(select id from Post order by id desc limit ?)
+		order by postId desc, position`, limit)
+	if err != nil {
+		tx.Rollback()
 		return descriptors, err
 	}
+	for rows.Next() {
+		var word string
+		if err := rows.Scan(&word); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return descriptors, err
+		}
+		descriptors = append(descriptors, word)
+	}
+	rows.Close()
 	return descriptors, tx.Commit()
 }
 
@@ -269,10 +800,15 @@ func (dbo *DbController) SelectAnonReactionCount(postId int) (int, error) {
 	return count, tx.Commit()
 }
 
-/* Adds a new row to the passcode table, with a generated hash */
-func (dbo *DbController) InsertHash(hash string) error {
+/* Adds a new row to the passcode table on a branch, with a generated hash. It
+is recorded against the post with id *postId*, which is the one that issued
+it. Zero expiry times are stored as null, meaning the passcode never expires */
+func (dbo *DbController) InsertHash(hash string,
+	branch, postId int, postExpires, reactExpires time.Time) error {
 	tx, _ := dbo.db.Begin()
-	_, err := tx.Exec(`insert into Passcode (hash) values (?)`, hash)
+	_, err := tx.Exec(`insert into Passcode (hash, postExpires, reactExpires,
+		branch, postId) values (?, ?, ?, ?, ?)`, hash,
+		nullableTime(postExpires), nullableTime(reactExpires), branch, postId)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -280,20 +816,651 @@ func (dbo *DbController) InsertHash(hash string) error {
 	return tx.Commit()
 }
 
-/* Clears db, for use in integration tests */
-func (dbo *DbController) Clear() bool {
-	queries := [3]string{`drop table Passcode`, `drop table Reaction`,
-		`drop table Post`}
+/* Selects the passcode row with the provided hash, including its lifetime */
+func (dbo *DbController) SelectPasscode(hash string) (tp.Passcode, error) {
+	var (
+		passcode                            tp.Passcode
+		issued, consumed, postExp, reactExp sql.NullTime
+		note                                sql.NullString
+		postId                              sql.NullInt64
+	)
 
-	// Execute all table creation on database
 	tx, _ := dbo.db.Begin()
-	for _, query := range queries {
-		_, err := tx.Exec(query)
-		if err != nil {
-			tx.Rollback()
-			return false
-		}
+	row, err := tx.Query(`select id, hash, issued, consumed, postExpires,
+		reactExpires, retired, note, branch, postId from Passcode
+		where hash = ?`, hash)
+	if err != nil {
+		tx.Rollback()
+		return passcode, err
 	}
-	dbo.db.Close()
-	return tx.Commit() == nil
+	if !row.Next() {
+		row.Close()
+		tx.Rollback()
+		return passcode, sql.ErrNoRows
+	}
+	if err = row.Scan(&passcode.Id, &passcode.Hash, &issued, &consumed,
+		&postExp, &reactExp, &passcode.Retired, &note, &passcode.Branch,
+		&postId); err != nil {
+		return passcode, err
+	}
+	row.Close()
+
+	// Null times are left as zero
+	passcode.Issued = issued.Time
+	passcode.Consumed = consumed.Time
+	passcode.PostExpires = postExp.Time
+	passcode.ReactExpires = reactExp.Time
+	passcode.Note = note.String
+	passcode.PostId = int(postId.Int64)
+	return passcode, tx.Commit()
+}
+
+/* Selects the branch of the passcode with the provided hash */
+func (dbo *DbController) SelectPasscodeBranch(hash string) (int, error) {
+	var branch int
+
+	tx, _ := dbo.db.Begin()
+	row, err := tx.Query(`select branch from Passcode where hash = ?`, hash)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if !row.Next() {
+		row.Close()
+		tx.Rollback()
+		return 0, sql.ErrNoRows
+	}
+	if err = row.Scan(&branch); err != nil {
+		return 0, err
+	}
+	row.Close()
+	return branch, tx.Commit()
+}
+
+/* Selects the id that the next new branch will take, one more than the
+highest branch so far */
+func (dbo *DbController) SelectNextBranch() (int, error) {
+	var branch int
+
+	tx, _ := dbo.db.Begin()
+	row, err := tx.Query(`select coalesce(max(branch), 0) + 1 from Passcode`)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	row.Next()
+	if err = row.Scan(&branch); err != nil {
+		return 0, err
+	}
+	row.Close()
+	return branch, tx.Commit()
+}
+
+/* Selects the id of the post that issued the latest passcode of a branch,
+which is the parent of the next post on it. Zero when the branch has no posts
+yet, such as before the genesis post */
+func (dbo *DbController) SelectBranchHeadId(branch int) (int, error) {
+	var postId int
+
+	tx, _ := dbo.db.Begin()
+	row, err := tx.Query(`select coalesce(
+		(select postId from Passcode where branch = ? and postId is not null
+			order by id desc limit 1),
+		(select max(id) from Post where branch = ?), 0)`, branch, branch)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	row.Next()
+	if err = row.Scan(&postId); err != nil {
+		return 0, err
+	}
+	row.Close()
+	return postId, tx.Commit()
+}
+
+/* Marks the passcode with the provided hash as consumed, once it has been used
+to make a post */
+func (dbo *DbController) ConsumeHash(hash string) error {
+	tx, _ := dbo.db.Begin()
+	_, err := tx.Exec(`update Passcode set consumed = current_timestamp
+		where hash = ? and consumed is null`, hash)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+/* Stores the sealed note left for the holder of the passcode with the
+provided hash */
+func (dbo *DbController) InsertHashNote(hash, note string) error {
+	tx, _ := dbo.db.Begin()
+	_, err := tx.Exec(`update Passcode set note = ? where hash = ?`, note, hash)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+/* Marks passcodes as retired once they have expired for both posting and
+reacting. Returns the number of passcodes retired */
+func (dbo *DbController) RetireExpiredHashes(now time.Time) (int64, error) {
+	tx, _ := dbo.db.Begin()
+	result, err := tx.Exec(`update Passcode set retired = 1 where retired = 0
+		and postExpires < ? and reactExpires < ?`,
+		now.UTC().Format(TIME_FORMAT), now.UTC().Format(TIME_FORMAT))
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	retired, _ := result.RowsAffected()
+	return retired, tx.Commit()
+}
+
+/* Adds a new unused genesis token, storing only its hash */
+func (dbo *DbController) InsertGenesisToken(hash string) error {
+	tx, _ := dbo.db.Begin()
+	_, err := tx.Exec(`insert into GenesisToken (hash) values (?)`, hash)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+/* Selects whether there is an unused genesis token with the provided hash,
+without using it up */
+func (dbo *DbController) SelectGenesisTokenUnused(hash string) (bool, error) {
+	return dbo.selectTokenUnused("GenesisToken", hash)
+}
+
+/* Selects whether there is an unused revival token with the provided hash,
+without using it up */
+func (dbo *DbController) SelectRevivalTokenUnused(hash string) (bool, error) {
+	return dbo.selectTokenUnused("RevivalToken", hash)
+}
+
+/* Selects whether the token table has an unused token with the provided
+hash */
+func (dbo *DbController) selectTokenUnused(
+	table, hash string) (bool, error) {
+	var count int
+
+	tx, _ := dbo.db.Begin()
+	row, err := tx.Query(fmt.Sprintf(`select count(*) from %s where hash = ?
+		and used = 0`, table), hash)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	row.Next()
+	if err = row.Scan(&count); err != nil {
+		return false, err
+	}
+	row.Close()
+	return count == 1, tx.Commit()
+}
+
+/* Adds a new unused revival token, storing only its hash */
+func (dbo *DbController) InsertRevivalToken(hash string) error {
+	tx, _ := dbo.db.Begin()
+	_, err := tx.Exec(`insert into RevivalToken (hash) values (?)`, hash)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+/* Records that the holder of a passcode forfeited their window, against the
+post with id *postId* that issued their passcode */
+func (dbo *DbController) InsertForfeit(passcodeId, postId int) error {
+	tx, _ := dbo.db.Begin()
+	_, err := tx.Exec(`insert into Forfeit (passcodeId, postId) values (?, ?)`,
+		passcodeId, postId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+/* Selects whether the holder of the latest passcode of a branch has
+forfeited */
+func (dbo *DbController) SelectHeadForfeited(branch int) (bool, error) {
+	var count int
+
+	tx, _ := dbo.db.Begin()
+	row, err := tx.Query(`select count(*) from Forfeit where passcodeId =
+		(select max(id) from Passcode where branch = ?)`, branch)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	row.Next()
+	if err = row.Scan(&count); err != nil {
+		return false, err
+	}
+	row.Close()
+	return count > 0, tx.Commit()
+}
+
+/* Records a fork from its source post, along with the genesis passcode of the
+fork. The passcode is recorded against the source post, so that the fork
+continues from it, while the fork's own time starts its clock. Returns the id
+of the fork */
+func (dbo *DbController) InsertFork(fork tp.Fork,
+	hash string, postExpires, reactExpires time.Time) (int, error) {
+	tx, _ := dbo.db.Begin()
+	_, err := tx.Exec(`insert into Passcode (hash, postExpires, reactExpires,
+		branch, postId) values (?, ?, ?, ?, ?)`, hash,
+		nullableTime(postExpires), nullableTime(reactExpires), fork.Branch,
+		fork.SourcePostId)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	var wordList interface{}
+	if fork.WordList != "" {
+		wordList = fork.WordList
+	}
+	result, err := tx.Exec(`insert into Fork (sourcePostId, branch, authority,
+		wordList) values (?, ?, ?, ?)`, fork.SourcePostId, fork.Branch,
+		fork.Authority, wordList)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return int(id), tx.Commit()
+}
+
+/* Selects all forks, oldest first */
+func (dbo *DbController) SelectForks() ([]tp.Fork, error) {
+	var forks []tp.Fork
+	tx, _ := dbo.db.Begin()
+
+	rows, err := tx.Query(`select id, sourcePostId, branch, authority, time,
+		coalesce(wordList, '') from Fork order by id asc`)
+	if err != nil {
+		tx.Rollback()
+		return forks, err
+	}
+	for rows.Next() {
+		var fork tp.Fork
+		if err = rows.Scan(&fork.Id, &fork.SourcePostId, &fork.Branch,
+			&fork.Authority, &fork.Time, &fork.WordList); err != nil {
+			return forks, err
+		}
+		forks = append(forks, fork)
+	}
+
+	rows.Close()
+	return forks, tx.Commit()
+}
+
+/* Selects the branch of a post */
+func (dbo *DbController) SelectPostBranch(postId int) (int, error) {
+	var branch int
+
+	tx, _ := dbo.db.Begin()
+	row, err := tx.Query(`select branch from Post where id = ?`, postId)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if !row.Next() {
+		row.Close()
+		tx.Rollback()
+		return 0, sql.ErrNoRows
+	}
+	if err = row.Scan(&branch); err != nil {
+		return 0, err
+	}
+	row.Close()
+	return branch, tx.Commit()
+}
+
+/* Selects the name of the word list that a post's descriptors were picked
+from. Posts from older versions have none, so it is empty */
+func (dbo *DbController) SelectPostWordList(postId int) (string, error) {
+	return dbo.selectWordList(`select coalesce(wordList, '') from Post
+		where id = ?`, postId)
+}
+
+/* Selects the name of the word list chosen by the fork that starts on the
+branch. Empty if the fork chose none */
+func (dbo *DbController) SelectForkWordList(branch int) (string, error) {
+	return dbo.selectWordList(`select coalesce(wordList, '') from Fork
+		where branch = ?`, branch)
+}
+
+/* Selects a single word list name with the query */
+func (dbo *DbController) selectWordList(query string, arg int) (string, error) {
+	var wordList string
+
+	tx, _ := dbo.db.Begin()
+	row, err := tx.Query(query, arg)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	if !row.Next() {
+		row.Close()
+		tx.Rollback()
+		return "", sql.ErrNoRows
+	}
+	if err = row.Scan(&wordList); err != nil {
+		return "", err
+	}
+	row.Close()
+	return wordList, tx.Commit()
+}
+
+/* Selects the time of each forfeit, keyed by the id of the post it was
+recorded against */
+func (dbo *DbController) SelectForfeits() (map[int]time.Time, error) {
+	forfeits := make(map[int]time.Time)
+	tx, _ := dbo.db.Begin()
+
+	rows, err := tx.Query(`select postId, time from Forfeit
+		where postId is not null`)
+	if err != nil {
+		tx.Rollback()
+		return forfeits, err
+	}
+	for rows.Next() {
+		var (
+			postId int
+			moment time.Time
+		)
+		if err = rows.Scan(&postId, &moment); err != nil {
+			return forfeits, err
+		}
+		forfeits[postId] = moment
+	}
+
+	rows.Close()
+	return forfeits, tx.Commit()
+}
+
+/* Adds the hash of a newly issued chain read token. Returns its id, which is
+used to revoke it */
+func (dbo *DbController) InsertReadToken(hash string) (int, error) {
+	tx, _ := dbo.db.Begin()
+	result, err := tx.Exec(`insert into ReadToken (hash) values (?)`, hash)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return int(id), tx.Commit()
+}
+
+/* Selects whether a read token with the provided hash exists and has not been
+revoked */
+func (dbo *DbController) SelectReadTokenActive(hash string) (bool, error) {
+	var count int
+
+	tx, _ := dbo.db.Begin()
+	row, err := tx.Query(`select count(*) from ReadToken where hash = ? and
+		revoked = 0`, hash)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	row.Next()
+	if err = row.Scan(&count); err != nil {
+		return false, err
+	}
+	row.Close()
+	return count > 0, tx.Commit()
+}
+
+/* Revokes the read token with the provided id. Returns whether there was an
+active token to revoke */
+func (dbo *DbController) RevokeReadToken(id int) (bool, error) {
+	tx, _ := dbo.db.Begin()
+	result, err := tx.Exec(`update ReadToken set revoked = 1 where id = ?
+		and revoked = 0`, id)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return affected == 1, tx.Commit()
+}
+
+/* Selects the id of the passcode row with the provided hash */
+func (dbo *DbController) SelectPasscodeId(hash string) (int, error) {
+	var id int
+
+	tx, _ := dbo.db.Begin()
+	row, err := tx.Query(`select id from Passcode where hash = ?`, hash)
+	if err != nil {
+		tx.Rollback()
+		return id, err
+	}
+	if !row.Next() {
+		row.Close()
+		tx.Rollback()
+		return id, sql.ErrNoRows
+	}
+	if err = row.Scan(&id); err != nil {
+		return id, err
+	}
+	row.Close()
+	return id, tx.Commit()
+}
+
+/* Selects the hash of the passcode row with id *id* */
+func (dbo *DbController) SelectPasscodeHash(id int) (string, error) {
+	var hash string
+
+	tx, _ := dbo.db.Begin()
+	row, err := tx.Query(`select hash from Passcode where id = ?`, id)
+	if err != nil {
+		tx.Rollback()
+		return hash, err
+	}
+	if !row.Next() {
+		row.Close()
+		tx.Rollback()
+		return hash, sql.ErrNoRows
+	}
+	if err = row.Scan(&hash); err != nil {
+		return hash, err
+	}
+	row.Close()
+	return hash, tx.Commit()
+}
+
+/* Replaces the hash of a passcode row, recording who rotated it, unless it has
+been rotated *limit* times since the provided moment. Returns whether it was
+rotated. Everything happens in the same transaction so that every rotation is
+audited. Any note is dropped as it was sealed under the replaced passcode */
+func (dbo *DbController) RotateHash(passcodeId int, hash, clientHash string,
+	since time.Time, limit int) (bool, error) {
+	tx, _ := dbo.db.Begin()
+
+	// Counting and recording the rotation in one statement, so that concurrent
+	// rotations cannot both pass the limit
+	result, err := tx.Exec(`insert into PasscodeRotation (passcodeId,
+		clientHash) select ?, ? where (select count(*) from PasscodeRotation
+		where passcodeId = ? and time > ?) < ?`, passcodeId, clientHash,
+		passcodeId, since.UTC().Format(TIME_FORMAT), limit)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil || affected == 0 {
+		tx.Rollback()
+		return false, err
+	}
+
+	_, err = tx.Exec(`update Passcode set hash = ?, note = null where id = ?`,
+		hash, passcodeId)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+/* Selects the number of times a passcode row has been rotated since the
+provided moment */
+func (dbo *DbController) SelectRotationCount(
+	passcodeId int, since time.Time) (int, error) {
+	var count int
+
+	tx, _ := dbo.db.Begin()
+	row, err := tx.Query(`select count(*) from PasscodeRotation where
+		passcodeId = ? and time > ?`, passcodeId,
+		since.UTC().Format(TIME_FORMAT))
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	row.Next()
+	if err = row.Scan(&count); err != nil {
+		return count, err
+	}
+	row.Close()
+	return count, tx.Commit()
+}
+
+/* Selects the most recent *limit* passcode rotations, latest first */
+func (dbo *DbController) SelectRotations(
+	limit int) ([]tp.PasscodeRotation, error) {
+	var rotations []tp.PasscodeRotation
+	tx, _ := dbo.db.Begin()
+
+	rows, err := tx.Query(`select id, passcodeId, clientHash, time from
+		PasscodeRotation order by id desc limit ?`, limit)
+	if err != nil {
+		tx.Rollback()
+		return rotations, err
+	}
+	for rows.Next() {
+		var rotation tp.PasscodeRotation
+		if err = rows.Scan(&rotation.Id, &rotation.PasscodeId,
+			&rotation.ClientHash, &rotation.Time); err != nil {
+			return rotations, err
+		}
+		rotations = append(rotations, rotation)
+	}
+
+	rows.Close()
+	return rotations, tx.Commit()
+}
+
+/* Adds a new security event to db, such as a failed passcode attempt */
+func (dbo *DbController) InsertSecurityEvent(event tp.SecurityEvent) error {
+	tx, _ := dbo.db.Begin()
+	_, err := tx.Exec(`insert into SecurityEvent (kind, reason, clientHash)
+		values (?, ?, ?)`, event.Kind, event.Reason, event.ClientHash)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+/* Selects the most recent *limit* security events, latest first */
+func (dbo *DbController) SelectSecurityEvents(
+	limit int) ([]tp.SecurityEvent, error) {
+	var events []tp.SecurityEvent
+	tx, _ := dbo.db.Begin()
+
+	// Getting rows from query
+	rows, err := tx.Query(`select id, kind, reason, clientHash, time from
+		SecurityEvent order by id desc limit ?`, limit)
+	if err != nil {
+		tx.Rollback()
+		return events, err
+	}
+
+	// Adding event rows from database table to the events variable
+	for rows.Next() {
+		var event tp.SecurityEvent
+		if err = rows.Scan(&event.Id, &event.Kind, &event.Reason,
+			&event.ClientHash, &event.Time); err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+
+	rows.Close()
+	return events, tx.Commit()
+}
+
+/* Selects the times of security events recorded against a client since the
+provided moment, latest first. Used to determine lockouts */
+func (dbo *DbController) SelectFailureTimes(
+	clientHash string, since time.Time) ([]time.Time, error) {
+	var times []time.Time
+	tx, _ := dbo.db.Begin()
+
+	// Stored timestamps are utc text, so comparing against the same format
+	rows, err := tx.Query(`select time from SecurityEvent where clientHash = ?
+		and time > ? order by id desc`, clientHash,
+		since.UTC().Format(TIME_FORMAT))
+	if err != nil {
+		tx.Rollback()
+		return times, err
+	}
+	for rows.Next() {
+		var moment time.Time
+		if err = rows.Scan(&moment); err != nil {
+			return times, err
+		}
+		times = append(times, moment)
+	}
+
+	rows.Close()
+	return times, tx.Commit()
+}
+
+/* Clears db, for use in integration tests */
+func (dbo *DbController) Clear() bool {
+	queries := [11]string{`drop table Passcode`, `drop table Reaction`,
+		`drop table PostDescriptor`, `drop table Post`,
+		`drop table SecurityEvent`, `drop table PasscodeRotation`,
+		`drop table GenesisToken`, `drop table ReadToken`,
+		`drop table Forfeit`, `drop table Fork`, `drop table RevivalToken`}
+
+	// Execute all table creation on database
+	tx, _ := dbo.db.Begin()
+	for _, query := range queries {
+		_, err := tx.Exec(query)
+		if err != nil {
+			tx.Rollback()
+			return false
+		}
+	}
+	dbo.db.Close()
+	return tx.Commit() == nil
+}
+
+/* Converts a time into the stored format, with zero times stored as null */
+func nullableTime(moment time.Time) interface{} {
+	if moment.IsZero() {
+		return nil
+	}
+	return moment.UTC().Format(TIME_FORMAT)
 }
@@ -1,8 +1,12 @@
 package controller
 
 import (
+	"database/sql"
 	"database/sql/driver"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -44,21 +48,51 @@ func TestSelectPostsSuccess(t *testing.T) {
 		sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 
 	// Mocking db operations by populating this mock database
-	headers := []string{"id", "title", "author", "contents", "tag",
-		"descriptors", "time"}
+	headers := []string{"id", "title", "author", "contents", "tag", "time",
+		"reveal", "passcodeId", "erased", "hashIndex", "role", "branch",
+		"parentId", "revival"}
 	rows := sqlmock.NewRows(headers).
 		AddRow(1, "test title", "tester", "testing is so cool", 3,
-			"t;t;t;t", time.Now()).
-		AddRow(2, "test title", "tester 2", "bruh", 4, "t;t;t;t", time.Now())
+			time.Now(), nil, nil, 0, nil, nil, 0, nil, nil).
+		AddRow(2, "test title", "tester 2", "bruh", 4, time.Now(),
+			time.Now().Add(time.Hour), 2, 0, 1, "recent", 1, 1, "work")
+	descriptorRows := sqlmock.NewRows([]string{"postId", "word"}).
+		AddRow(1, "ripe").AddRow(1, "calm").AddRow(2, "bold")
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(`select * from Post order by id desc`).WillReturnRows(rows)
+	mock.ExpectQuery(`select id, title, author, contents, tag, time,
+		reveal, passcodeId, erased, hashIndex, role, branch, parentId, revival
+		from Post order by id desc`).
+		WillReturnRows(rows)
+	mock.ExpectQuery(`select postId, word from PostDescriptor
+		order by postId, position`).
+		WillReturnRows(descriptorRows)
 	mock.ExpectCommit()
 
 	// Running the real function with above parameters
-	if _, err = testDbo.SelectPosts(); err != nil {
+	posts, err := testDbo.SelectPosts()
+	if err != nil {
 		t.Logf("error not expected when grabbing posts: %s", err)
 		t.Fail()
+	} else if posts[0].Reveal != nil || posts[1].Reveal == nil {
+		t.Log("expected only the second post to have a reveal time")
+		t.Fail()
+	} else if posts[0].PasscodeId != 0 || posts[1].PasscodeId != 2 {
+		t.Log("expected only the second post to have a passcode id")
+		t.Fail()
+	} else if posts[0].HashIndex != -1 || posts[1].HashIndex != 1 ||
+		posts[0].Role != "" || posts[1].Role != "recent" {
+		t.Log("expected only the second post to have a hash index and role")
+		t.Fail()
+	} else if posts[0].ParentId != 0 || posts[1].ParentId != 1 ||
+		posts[1].Branch != 1 {
+		t.Log("expected only the second post to branch from the first")
+		t.Fail()
+	} else if posts[0].Descriptors != "ripe;calm" ||
+		posts[1].Descriptors != "bold" {
+		t.Logf("descriptors not joined in order: %s, %s",
+			posts[0].Descriptors, posts[1].Descriptors)
+		t.Fail()
 	}
 	teardownTest(t)
 }
@@ -69,7 +103,7 @@ func TestSelectPostsFailure(t *testing.T) {
 	setupTest(t)
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(`select * from Post order by id desc`).
+	mock.ExpectQuery(`select (.+) from Post order by id desc`).
 		WillReturnError(fmt.Errorf("some error"))
 	mock.ExpectRollback()
 
@@ -81,6 +115,24 @@ func TestSelectPostsFailure(t *testing.T) {
 	testDbo.db.Close()
 }
 
+/* Tests that a descriptor that cannot be read closes its rows and rolls back
+the transaction */
+func TestSelectRecentDescriptorsFailure(t *testing.T) {
+	setupTest(t)
+
+	rows := sqlmock.NewRows([]string{"word"}).AddRow("calm").AddRow(nil)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`select word from PostDescriptor`).
+		WillReturnRows(rows).RowsWillBeClosed()
+	mock.ExpectRollback()
+
+	if _, err = testDbo.SelectRecentDescriptors(10); err == nil {
+		t.Log("expecting error when reading an unreadable descriptor")
+		t.Fail()
+	}
+	teardownTest(t)
+}
+
 /* Tests that selecting candidate hashes works as expected*/
 func TestSelectCandidateHashes(t *testing.T) {
 	setupTest(t)
@@ -99,18 +151,20 @@ func TestSelectCandidateHashes(t *testing.T) {
 	rows := sqlmock.NewRows([]string{"hash"}).AddRow(sampleHashes[0]).
 		AddRow(sampleHashes[1]).AddRow(sampleHashes[2]).
 		AddRow(sampleHashes[3])
-	mock.ExpectQuery(`select hash from Passcode order by id desc limit 4`).
-		WillReturnRows(rows)
+	mock.ExpectQuery(
+		`select hash from Passcode where branch = ? order by id desc limit ?`).
+		WithArgs(0, 4).WillReturnRows(rows)
 
 	// Testing getting genesis hash
 	rows2 := sqlmock.NewRows([]string{"hash"}).
 		AddRow("9f86d081884c7d659a2feaa055ad015a3bf4f1b2b0b822cd15d6c15b0f00a0bc")
-	mock.ExpectQuery(`select hash from Passcode order by id asc limit 1`).
-		WillReturnRows(rows2)
+	mock.ExpectQuery(`select hash from Passcode where branch = ?
+		order by id asc limit 1`).
+		WithArgs(0).WillReturnRows(rows2)
 
 	// Tests that these hashes are correctly sandwiched together
 	mock.ExpectCommit()
-	testHashes, err := testDbo.SelectCandidateHashes()
+	testHashes, err := testDbo.SelectCandidateHashes(0, 5)
 	if err != nil {
 		t.Logf("error not expected when selecting hashes: %s", err)
 		t.Fail()
@@ -136,13 +190,19 @@ func TestInsertPostSuccess(t *testing.T) {
 	mock.ExpectBegin()
 	mock.ExpectExec("insert into Post").
 		WithArgs(testPost.Title, testPost.Author, testPost.Contents,
-			testPost.Descriptors, testPost.Tag).
+			testPost.Tag, nil, nil, 0, nil, 0, nil, nil, nil).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	for position := 0; position < 10; position++ {
+		mock.ExpectExec("insert into PostDescriptor").
+			WithArgs(1, position, "test").
+			WillReturnResult(sqlmock.NewResult(int64(position+1), 1))
+	}
 	mock.ExpectCommit()
 
 	// Adding test post to mock database
-	if err = testDbo.InsertPost(testPost); err != nil {
-		t.Logf("error not expected when adding post: %s", err)
+	postId, err := testDbo.InsertPost(testPost)
+	if err != nil || postId != 1 {
+		t.Logf("error not expected when adding post %d: %v", postId, err)
 		t.Fail()
 	}
 	teardownTest(t)
@@ -164,17 +224,384 @@ func TestInsertPostFailure(t *testing.T) {
 	mock.ExpectBegin()
 	mock.ExpectExec("insert into Post").
 		WithArgs(testPost.Title, testPost.Author, testPost.Contents,
-			testPost.Descriptors, testPost.Tag).
+			testPost.Tag, nil, nil, 0, nil, 0, nil, nil, nil).
 		WillReturnError(fmt.Errorf("some error"))
 	mock.ExpectRollback()
 
 	// Adding test post to mock database
-	if err = testDbo.InsertPost(testPost); err == nil {
+	if _, err = testDbo.InsertPost(testPost); err == nil {
 		t.Logf("was expecting error when adding post: %s", err)
 	}
 	teardownTest(t)
 }
 
+/* Tests that a security event is inserted with its kind, reason and client */
+func TestInsertSecurityEvent(t *testing.T) {
+	setupTest(t)
+
+	testEvent := tp.SecurityEvent{
+		Kind:       "failed_validation",
+		Reason:     "a: hash will never have ability to make post",
+		ClientHash: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec("insert into SecurityEvent").
+		WithArgs(testEvent.Kind, testEvent.Reason, testEvent.ClientHash).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	if err = testDbo.InsertSecurityEvent(testEvent); err != nil {
+		t.Logf("error not expected when adding security event: %s", err)
+		t.Fail()
+	}
+	teardownTest(t)
+}
+
+/* Tests that only passcodes expired for both posting and reacting are
+retired */
+func TestRetireExpiredHashes(t *testing.T) {
+	setupTest(t)
+	now := time.Date(2024, 6, 4, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("update Passcode set retired = 1").
+		WithArgs("2024-06-04 12:00:00", "2024-06-04 12:00:00").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	retired, err := testDbo.RetireExpiredHashes(now)
+	if err != nil || retired != 2 {
+		t.Logf("expected 2 retired passcodes, found %d with %v", retired, err)
+		t.Fail()
+	}
+	teardownTest(t)
+}
+
+/* Tests that initialising over a database created by an older version adds
+the missing columns, keeping existing rows, and moves descriptors strings into
+their own rows */
+func TestInitMigratesLegacyTables(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	legacy, _ := sql.Open("sqlite3", path)
+	for _, query := range []string{
+		`create table Passcode (
+			id integer primary key autoincrement not null,
+			hash varchar(64) not null)`,
+		`insert into Passcode (hash) values ('legacy')`,
+		`create table Post (
+			id integer primary key autoincrement not null,
+			title varchar(40) not null unique,
+			author varchar(10),
+			contents varchar(1500) not null,
+			tag integer not null,
+			descriptors varchar(210),
+			time datetime default current_timestamp)`,
+		`insert into Post (title, contents, tag, descriptors)
+			values ('legacy', 'old post', 1, 'unripe;ripe')`,
+		`create table Reaction (
+			id integer primary key autoincrement not null,
+			postId integer not null,
+			descriptor varchar(20) not null,
+			gravitas integer not null,
+			gravitasHash varchar(64))`,
+		`insert into Reaction (postId, descriptor, gravitas)
+			values (1, 'ripe', 0), (1, 'rip', 0)`,
+	} {
+		if _, err = legacy.Exec(query); err != nil {
+			break
+		}
+	}
+	legacy.Close()
+	if err != nil {
+		t.Fatalf("unable to create legacy database: %s", err)
+	}
+
+	os.Setenv("DB_FILEPATH", path)
+	migrated := DbController{}
+	if err = migrated.Init(); err != nil {
+		t.Fatalf("unable to initialise legacy database: %s", err)
+	}
+	defer migrated.db.Close()
+
+	passcode, err := migrated.SelectPasscode("legacy")
+	if err != nil || passcode.Issued.IsZero() || passcode.Retired {
+		t.Logf("legacy passcode not migrated: %+v, %v", passcode, err)
+		t.Fail()
+	}
+
+	// Only the reaction naming a descriptor exactly is linked to it
+	descriptors, err := migrated.SelectPostDescriptors(1)
+	if err != nil || len(descriptors) != 2 || descriptors[1].Word != "ripe" {
+		t.Fatalf("legacy descriptors not migrated: %+v, %v", descriptors, err)
+	}
+	var linked []sql.NullInt64
+	rows, _ := migrated.db.Query(`select descriptorId from Reaction
+		order by id`)
+	for rows.Next() {
+		var descriptorId sql.NullInt64
+		rows.Scan(&descriptorId)
+		linked = append(linked, descriptorId)
+	}
+	rows.Close()
+	if len(linked) != 2 || linked[0].Int64 != int64(descriptors[1].Id) ||
+		linked[1].Valid {
+		t.Logf("legacy reactions linked incorrectly: %+v", linked)
+		t.Fail()
+	}
+}
+
+/* Tests that a fork's clock starts when it is made rather than at its source
+post, until its first post */
+func TestForkTimestamp(t *testing.T) {
+	os.Setenv("DB_FILEPATH", filepath.Join(t.TempDir(), "fork.db"))
+	forking := DbController{}
+	if err := forking.Init(); err != nil {
+		t.Fatalf("unable to initialise database: %s", err)
+	}
+	defer forking.db.Close()
+	old := time.Now().Add(-time.Hour * 24 * 40).UTC()
+	_, err := forking.db.Exec(`insert into Post (title, contents, tag, time)
+		values ('old', 'source', 1, ?)`, old.Format(TIME_FORMAT))
+	if err != nil {
+		t.Fatalf("unable to insert source post: %s", err)
+	}
+
+	fork := tp.Fork{SourcePostId: 1, Branch: 1, Authority: "admin"}
+	if _, err = forking.InsertFork(
+		fork, "forkgenesis", time.Time{}, time.Time{}); err != nil {
+		t.Fatalf("unable to insert fork: %s", err)
+	}
+	forkTime, err := forking.SelectLatestTimestamp(1)
+	if err != nil || time.Since(forkTime) > time.Minute {
+		t.Fatalf("expected the fork's clock to start now, found %v, %v",
+			forkTime, err)
+	}
+	sourceTime, err := forking.SelectLatestTimestamp(0)
+	if err != nil || !sourceTime.Equal(old.Truncate(time.Second)) {
+		t.Logf("expected the source branch to keep its time, found %v, %v",
+			sourceTime, err)
+		t.Fail()
+	}
+
+	// Once the fork has a post, its head passcode's post is used as before
+	recent := time.Now().Add(-time.Hour).UTC()
+	_, err = forking.db.Exec(`insert into Post (title, contents, tag, time,
+		branch, parentId) values ('first', 'fork', 1, ?, 1, 1)`,
+		recent.Format(TIME_FORMAT))
+	if err == nil {
+		_, err = forking.db.Exec(`insert into Passcode (hash, branch, postId)
+			values ('next', 1, 2)`)
+	}
+	if err != nil {
+		t.Fatalf("unable to post on fork: %s", err)
+	}
+	forkTime, err = forking.SelectLatestTimestamp(1)
+	if err != nil || !forkTime.Equal(recent.Truncate(time.Second)) {
+		t.Logf("expected the fork post's time, found %v, %v", forkTime, err)
+		t.Fail()
+	}
+}
+
+/* Tests that a passcode is recorded against the post that issued it, even when
+a post on another branch has been made since */
+func TestInsertHashPost(t *testing.T) {
+	os.Setenv("DB_FILEPATH", filepath.Join(t.TempDir(), "hash.db"))
+	branching := DbController{}
+	if err := branching.Init(); err != nil {
+		t.Fatalf("unable to initialise database: %s", err)
+	}
+	defer branching.db.Close()
+	issuer, err := branching.InsertPost(tp.Post{Title: "issuer",
+		Contents: "first branch", Tag: 1, HashIndex: -1})
+	if err != nil {
+		t.Fatalf("unable to insert issuing post: %s", err)
+	}
+	_, err = branching.InsertPost(tp.Post{Title: "other", Contents: "second",
+		Tag: 1, HashIndex: -1, Branch: 1})
+	if err != nil {
+		t.Fatalf("unable to insert post on other branch: %s", err)
+	}
+
+	err = branching.InsertHash("next", 0, issuer, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("unable to insert hash: %s", err)
+	}
+	passcode, err := branching.SelectPasscode("next")
+	headId, err2 := branching.SelectBranchHeadId(0)
+	if err != nil || err2 != nil || passcode.PostId != issuer ||
+		headId != issuer {
+		t.Logf("expected passcode issued by post %d, found %d and head %d",
+			issuer, passcode.PostId, headId)
+		t.Fail()
+	}
+}
+
+/* Tests that the genesis token is only used up once the genesis post has been
+stored, so a genesis post that fails can be retried with the same token */
+func TestInsertGenesisPost(t *testing.T) {
+	os.Setenv("DB_FILEPATH", filepath.Join(t.TempDir(), "genesis.db"))
+	genesis := DbController{}
+	if err := genesis.Init(); err != nil {
+		t.Fatalf("unable to initialise database: %s", err)
+	}
+	defer genesis.db.Close()
+	if err := genesis.InsertGenesisToken("token"); err != nil {
+		t.Fatalf("unable to insert genesis token: %s", err)
+	}
+
+	// A tag out of range fails the insert, leaving the token unused
+	post := tp.Post{Title: "genesis", Contents: "first", Tag: 9,
+		Descriptors: "calm;bold", HashIndex: -1}
+	if _, err := genesis.InsertGenesisPost(post, "token"); err == nil {
+		t.Fatal("expected the invalid genesis post to fail")
+	}
+	isUnused, err := genesis.SelectGenesisTokenUnused("token")
+	if err != nil || !isUnused {
+		t.Fatalf("expected the token to survive a failed post: %v", err)
+	}
+
+	post.Tag = 0
+	postId, err := genesis.InsertGenesisPost(post, "token")
+	if err != nil || postId != 1 {
+		t.Fatalf("unable to insert genesis post: %v", err)
+	}
+	isUnused, _ = genesis.SelectGenesisTokenUnused("token")
+	postId, err = genesis.InsertGenesisPost(post, "token")
+	if isUnused || postId != 0 || err != nil {
+		t.Logf("expected the token to be used up, found %v, %d, %v",
+			isUnused, postId, err)
+		t.Fail()
+	}
+}
+
+/* Tests that a revival token survives a revival post whose insert fails, such
+as one with a taken title, and is used up once a revival post is stored */
+func TestInsertRevivalPost(t *testing.T) {
+	os.Setenv("DB_FILEPATH", filepath.Join(t.TempDir(), "revival.db"))
+	revival := DbController{}
+	if err := revival.Init(); err != nil {
+		t.Fatalf("unable to initialise database: %s", err)
+	}
+	defer revival.db.Close()
+	post := tp.Post{Title: "taken", Contents: "first", Tag: 1,
+		Descriptors: "calm;bold", HashIndex: -1}
+	_, err := revival.InsertPost(post)
+	if err == nil {
+		err = revival.InsertRevivalToken("token")
+	}
+	if err != nil {
+		t.Fatalf("unable to set up revival: %s", err)
+	}
+
+	post.Revival = "token"
+	if _, err = revival.InsertRevivalPost(post, "token"); err == nil {
+		t.Fatal("expected the revival post with a taken title to fail")
+	}
+	isUnused, err := revival.SelectRevivalTokenUnused("token")
+	if err != nil || !isUnused {
+		t.Fatalf("expected the token to survive a failed post: %v", err)
+	}
+
+	post.Title = "revived"
+	postId, err := revival.InsertRevivalPost(post, "token")
+	if err != nil || postId != 2 {
+		t.Fatalf("unable to insert revival post: %v", err)
+	}
+	isUnused, _ = revival.SelectRevivalTokenUnused("token")
+	post.Title = "revived again"
+	postId, err = revival.InsertRevivalPost(post, "token")
+	if isUnused || postId != 0 || err != nil {
+		t.Logf("expected the token to be used up, found %v, %d, %v",
+			isUnused, postId, err)
+		t.Fail()
+	}
+}
+
+/* Tests that erasing a post deletes its descriptors, while its kept reactions
+no longer point at them */
+func TestErasePostDescriptors(t *testing.T) {
+	os.Setenv("DB_FILEPATH", filepath.Join(t.TempDir(), "erase.db"))
+	erasing := DbController{}
+	if err := erasing.Init(); err != nil {
+		t.Fatalf("unable to initialise database: %s", err)
+	}
+	defer erasing.db.Close()
+	postId, err := erasing.InsertPost(tp.Post{Title: "regret", Contents: "oops",
+		Tag: 1, Descriptors: "calm;bold", HashIndex: -1})
+	if err != nil {
+		t.Fatalf("unable to insert post: %s", err)
+	}
+	descriptors, _ := erasing.SelectPostDescriptors(postId)
+	err = erasing.InsertReaction(tp.Reaction{PostId: postId, Descriptor: "calm",
+		Gravitas: 2, DescriptorId: descriptors[0].Id})
+	if err == nil {
+		err = erasing.ErasePost(postId, "erased", "", false)
+	}
+	if err != nil {
+		t.Fatalf("unable to erase post: %s", err)
+	}
+
+	var kept, pointing int
+	descriptors, err = erasing.SelectPostDescriptors(postId)
+	erasing.db.QueryRow(`select count(*), count(descriptorId) from Reaction
+		where postId = ?`, postId).Scan(&kept, &pointing)
+	if err != nil || len(descriptors) != 0 || kept != 1 || pointing != 0 {
+		t.Logf("expected no descriptors and 1 unlinked reaction, found %v, "+
+			"%d reactions with %d linked: %v", descriptors, kept, pointing, err)
+		t.Fail()
+	}
+}
+
+/* Tests that concurrent rotations of a passcode never go over the limit, as
+the limit is checked in the same statement that records the rotation */
+func TestRotateHashLimit(t *testing.T) {
+	os.Setenv("DB_FILEPATH", filepath.Join(t.TempDir(), "rotate.db"))
+	rotating := DbController{}
+	if err := rotating.Init(); err != nil {
+		t.Fatalf("unable to initialise database: %s", err)
+	}
+	defer rotating.db.Close()
+	_, err := rotating.db.Exec(`insert into Passcode (hash) values ('head')`)
+	if err != nil {
+		t.Fatalf("unable to insert passcode: %s", err)
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		rotated int
+	)
+	since := time.Now().Add(-time.Hour * 24)
+	rotate := func(i int) {
+		isRotated, err := rotating.RotateHash(
+			1, fmt.Sprintf("hash%d", i), "client", since, 2)
+		if err == nil && isRotated {
+			mu.Lock()
+			rotated++
+			mu.Unlock()
+		}
+	}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rotate(i)
+		}(i)
+	}
+	wg.Wait()
+
+	// Any rotations refused only for being busy can still reach the limit
+	for i := 8; i < 11; i++ {
+		rotate(i)
+	}
+	var count int
+	rotating.db.QueryRow(`select count(*) from PasscodeRotation`).Scan(&count)
+	if rotated != 2 || count != 2 {
+		t.Logf("expected 2 rotations, found %d recorded as %d", rotated, count)
+		t.Fail()
+	}
+}
+
 /* Called at the end of every test; ensuring all expectations met and database
 is cleared */
 func teardownTest(t *testing.T) {
@@ -7,13 +7,20 @@ package main
 
 import (
 	"embed"
+	"fmt"
 	"io/fs"
+	"log"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	config "github.com/georgejmx/whisper-blog/config"
 	r "github.com/georgejmx/whisper-blog/routes"
+	x "github.com/georgejmx/whisper-blog/security"
+	w "github.com/georgejmx/whisper-blog/words"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/ratelimit"
 )
@@ -21,29 +28,96 @@ import (
 //go:embed client/public/*
 var client embed.FS
 
-/* Program entry point when used in production */
+/* Program entry point when used in production. Running with the *genesis*
+argument instead prints a one-time genesis token for the first post */
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "genesis" {
+		printGenesisToken()
+		return
+	}
+
 	rl := ratelimit.New(150)
-	setup(true, rl).Run(":8007")
+	router := setup(true, rl)
+
+	// Serving plain http unless a certificate has been configured
+	if os.Getenv("TLS_CERT_FILE") == "" {
+		log.Fatal(router.Run(os.Getenv("HTTP_ADDR")))
+	}
+	log.Fatal(serveTls(router))
 }
 
 /* Read configuration and setup production or test server */
 func setup(isProduction bool, rl ratelimit.Limiter) *gin.Engine {
 	// Setting config
 	config.SetupEnv(isProduction)
+	if err := x.CheckSessionKeys(); err != nil {
+		log.Fatalf("unable to sign sessions: %v, set SESSION_KEYS or "+
+			"SESSION_KEYS_FILE", err)
+	}
+	lists, err := w.LoadDir(os.Getenv("WORD_LIST_DIR"))
+	if err != nil {
+		log.Fatalf("unable to load word lists: %v", err)
+	}
+	filter, err := w.LoadFilter(
+		os.Getenv("WORD_ALLOWLIST"), os.Getenv("WORD_BLOCKLIST"))
+	if err != nil {
+		log.Fatalf("unable to load word filter: %v", err)
+	}
+	w.SetFilter(filter)
+	mode := os.Getenv("DESCRIPTOR_MODE")
+	if mode != w.MODE_RANDOM && mode != w.MODE_CONTENT {
+		log.Fatalf("unknown DESCRIPTOR_MODE %q, set it to %s or %s", mode,
+			w.MODE_RANDOM, w.MODE_CONTENT)
+	}
+
+	// Every list a post could use must still describe it once filtered
+	defaultList, err := w.Lookup("")
+	if err != nil {
+		log.Fatalf("unable to find default word list: %v", err)
+	}
+	for _, list := range append(lists, defaultList) {
+		if err := w.CheckList(list); err != nil {
+			log.Fatalf("word list %s has fewer than %d allowed words: %v",
+				list.Name(), w.DESCRIPTOR_SIZE, err)
+		}
+	}
 
-	// Setting up database connection, rate limiting, router and cors
+	// Setting up database connection, passcode sweep, rate limiting, router
+	// and security policy
 	r.SetupDatabase()
+	r.SweepPasscodes(time.Hour)
 	r.Rl = rl
+	statusLimit, _ := strconv.Atoi(os.Getenv("STATUS_LIMIT"))
+	r.StatusRl = x.NewClientLimiter(statusLimit, time.Minute)
 	router := gin.Default()
-	router.Use(cors.Default())
+	router.Use(r.SecurityPolicy())
 
 	// Defining routes
 	router.GET("/data/chain", r.GetRawChain)
+	router.GET("/data/chain/law", r.GetChainLaw)
+	router.GET("/data/chain/law.ics", r.GetChainLawCalendar)
+	router.GET("/data/tree", r.GetTree)
+	router.GET("/data/forks", r.GetForks)
+	router.GET("/data/revival", r.GetRevivalChallenge)
 	router.POST("/data/post", r.AddPost)
 	router.POST("/data/react", r.AddReaction)
+	router.POST("/data/session", r.AddSession)
+	router.POST("/data/rotate", r.RotatePasscode)
+	router.POST("/data/combine", r.CombineShares)
+	router.POST("/data/note", r.GetHolderNote)
+	router.POST("/data/read", r.SetReadCookie)
+	router.POST("/data/erase", r.ErasePost)
+	router.POST("/data/forfeit", r.ForfeitPasscode)
+	router.POST("/data/status", r.GetPasscodeStatus)
+	router.POST("/data/fork", r.ForkPost)
 	router.GET("/html/chain", r.GetHtmlChain)
 	router.GET("/html/reaction/:id", r.GetHtmlReactions)
+	router.GET("/admin/events", r.GetSecurityEvents)
+	router.GET("/admin/rotations", r.GetRotations)
+	router.POST("/admin/read-tokens", r.AddReadToken)
+	router.DELETE("/admin/read-tokens/:id", r.DeleteReadToken)
+	router.POST("/admin/forks/:id", r.AddFork)
+	router.POST("/admin/revival-tokens", r.AddRevivalToken)
 
 	// Serving client at root directory
 	stripped, err := fs.Sub(client, "client/public")
@@ -53,3 +127,59 @@ func setup(isProduction bool, rl ratelimit.Limiter) *gin.Engine {
 
 	return router
 }
+
+/* Issues a genesis token and prints it. Only its hash is stored, so this is
+the only time that it is shown */
+func printGenesisToken() {
+	config.SetupEnv(true)
+	r.SetupDatabase()
+	token, err := r.IssueGenesisToken()
+	if err != nil {
+		log.Fatal("unable to issue genesis token")
+	}
+	fmt.Printf("genesis token: %s\n", token)
+	fmt.Println("use it as the passcode of the genesis post, it is shown once")
+}
+
+/* Serves over https using the configured certificate, which is reloaded on
+SIGHUP. A second listener redirects plain http requests to https */
+func serveTls(handler http.Handler) error {
+	reloader, err := x.NewCertReloader(
+		os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE"))
+	if err != nil {
+		return err
+	}
+	reloader.ReloadOnSighup()
+	tlsConf, err := x.TlsConfig(reloader)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		log.Fatal(http.ListenAndServe(
+			os.Getenv("HTTP_ADDR"), redirectToHttps(os.Getenv("HTTPS_ADDR"))))
+	}()
+	server := &http.Server{
+		Addr:      os.Getenv("HTTPS_ADDR"),
+		Handler:   handler,
+		TLSConfig: tlsConf,
+	}
+	return server.ListenAndServeTLS("", "")
+}
+
+/* Redirects plain http requests to the same host and path on the https
+listener */
+func redirectToHttps(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if hostname, _, err := net.SplitHostPort(req.Host); err == nil {
+			host = hostname
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, req, "https://"+host+req.URL.RequestURI(),
+			http.StatusPermanentRedirect)
+	})
+}
@@ -11,11 +11,13 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	r "github.com/georgejmx/whisper-blog/routes"
 	x "github.com/georgejmx/whisper-blog/security"
 	tp "github.com/georgejmx/whisper-blog/types"
 	u "github.com/georgejmx/whisper-blog/utils"
+	w "github.com/georgejmx/whisper-blog/words"
 	"go.uber.org/ratelimit"
 )
 
@@ -36,7 +38,7 @@ var (
 	testServer               *httptest.Server
 	testPostReqBodies        = u.TestPosts
 	testInvalidPostReqBodies = u.TestInvalidPosts
-	passHashes               = []string{x.RawToHash("gen6si9")}
+	passHashes               []string
 	invalidHashes            = u.InvalidMockHashes
 	hasMaxAnonHash           = false
 )
@@ -46,6 +48,13 @@ func TestMain(m *testing.M) {
 	r.IsTimeGuarded = false
 	rl := ratelimit.New(150)
 	testServer = httptest.NewServer(setup(false, rl))
+
+	// Genesis token is the first passcode, with its hash heading the list
+	genesisToken, err := r.IssueGenesisToken()
+	if err != nil {
+		log.Fatal("unable to issue genesis token")
+	}
+	passHashes = []string{x.RawToHash(genesisToken)}
 	code := m.Run()
 	teardownAll()
 	os.Exit(code)
@@ -66,6 +75,66 @@ func TestProcess(t *testing.T) {
 	}
 }
 
+/* Tests that the security policy applies to every route, and that cross origin
+requests are only allowed from configured origins */
+func TestSecurityPolicy(t *testing.T) {
+	for _, path := range []string{"/w/", "/data/chain", "/html/chain"} {
+		resp, err := http.Get(fmt.Sprintf("%s%s", testServer.URL, path))
+		if err != nil {
+			t.Fatalf("unable to request %s", path)
+		}
+		if resp.Header.Get("Content-Security-Policy") == "" ||
+			resp.Header.Get("X-Content-Type-Options") != "nosniff" ||
+			resp.Header.Get("Referrer-Policy") == "" {
+			t.Logf("security headers missing from %s: %v", path, resp.Header)
+			t.Fail()
+		} else if resp.Header.Get("Access-Control-Allow-Origin") != "" {
+			t.Logf("unexpected allow origin header on %s", path)
+			t.Fail()
+		}
+	}
+
+	// Preflight from the configured origin should succeed
+	req, _ := http.NewRequest(
+		"OPTIONS", fmt.Sprintf("%s/data/post", testServer.URL), nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != 204 || resp.Header.Get(
+		"Access-Control-Allow-Origin") != "http://localhost:3000" {
+		t.Log("preflight from allowed origin was not accepted")
+		t.Fail()
+	}
+
+	// Any other origin should be refused
+	req.Header.Set("Origin", "http://evil.example")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != 403 {
+		t.Log("preflight from unknown origin was not refused")
+		t.Fail()
+	}
+}
+
+/* Tests that plain http requests are redirected to the same path over https */
+func TestRedirectToHttps(t *testing.T) {
+	cases := map[string]string{
+		":443":  "https://example.com/w?x=1",
+		":8443": "https://example.com:8443/w?x=1",
+	}
+	for httpsAddr, expected := range cases {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://example.com:8007/w?x=1", nil)
+		redirectToHttps(httpsAddr).ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusPermanentRedirect ||
+			recorder.Header().Get("Location") != expected {
+			t.Logf("expected redirect to %s, found %d %s", expected,
+				recorder.Code, recorder.Header().Get("Location"))
+			t.Fail()
+		}
+	}
+}
+
 /* Tests that the AddPost route behaves properly */
 func TestAddPostSuccess(t *testing.T) {
 	// add genesis post if not done already
@@ -187,6 +256,13 @@ func TestAddAnonReaction(t *testing.T) {
 	lastPostId := latestPost.Id
 	descriptors := strings.Split(latestPost.Descriptors, ";")
 
+	// Part of a descriptor is not a descriptor
+	addReaction(false, t, lastPostId, descriptors[0][1:], "")
+
+	// A full length hash that is not a candidate is refused, not taken as an
+	// anonymous reaction
+	addReaction(false, t, lastPostId, descriptors[0], invalidHashes[1])
+
 	// Expecting 6 successes, then a failure. Validates behaviour
 	i := 0
 	for i < 6 {
@@ -244,6 +320,1130 @@ func TestAddSignedReaction(t *testing.T) {
 	addReaction(false, t, lastPostId, descriptors[9], passHashes[maxInd])
 }
 
+/* Checks that failed attempts made by earlier tests are visible to an admin,
+and that the events route refuses anyone else */
+func TestGetSecurityEvents(t *testing.T) {
+	var eventsResp struct {
+		Marker int                `json:"marker"`
+		Events []tp.SecurityEvent `json:"events"`
+	}
+	if len(passHashes) < 5 {
+		TestAddPostFailure(t)
+	}
+
+	// Requesting without the admin key should be refused
+	resp, err := http.Get(fmt.Sprintf("%s/admin/events", testServer.URL))
+	if err != nil {
+		t.Fatal("unable to get security events")
+	} else if resp.StatusCode != 401 {
+		t.Fatalf("expected 401 without admin key, found %d", resp.StatusCode)
+	}
+
+	// Requesting with the admin key should list recent failures
+	req, _ := http.NewRequest(
+		"GET", fmt.Sprintf("%s/admin/events?limit=5", testServer.URL), nil)
+	req.Header.Set("X-Admin-Key", os.Getenv("ADMIN_KEY"))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("unable to get security events as admin")
+	}
+	respData, _ := io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &eventsResp)
+	if eventsResp.Marker != 1 || len(eventsResp.Events) == 0 ||
+		len(eventsResp.Events) > 5 {
+		t.Logf("unexpected events response: %s", string(respData))
+		t.Fail()
+	}
+
+	// The unauthorised request above should be the latest event
+	if len(eventsResp.Events) > 0 &&
+		eventsResp.Events[0].Kind != x.EVENT_FAILED_VALIDATION {
+		t.Logf("expected failed validation, found %s",
+			eventsResp.Events[0].Kind)
+		t.Fail()
+	}
+
+	// Admin routes are disabled while no admin key is set
+	adminKey := os.Getenv("ADMIN_KEY")
+	os.Setenv("ADMIN_KEY", "")
+	req.Header.Set("X-Admin-Key", "")
+	resp, err = http.DefaultClient.Do(req)
+	os.Setenv("ADMIN_KEY", adminKey)
+	if err != nil || resp.StatusCode != 503 {
+		t.Logf("expected admin routes to be disabled, found %v", err)
+		t.Fail()
+	}
+}
+
+/* Checks that a session token can be used in place of the hash to make a post,
+and that it is revoked once the chain advances */
+func TestSessionPost(t *testing.T) {
+	if len(passHashes) < 5 {
+		TestAddPostSuccess(t)
+	}
+	latestHash := passHashes[len(passHashes)-1]
+
+	// Exchanging the latest hash for a session token
+	jsonBody, _ := json.Marshal(map[string]string{"hash": latestHash})
+	resp, err := http.Post(fmt.Sprintf("%s/data/session", testServer.URL),
+		"application/json", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		t.Fatal("unable to start session")
+	}
+	respData, _ := io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &respJson)
+	if respJson.Marker != 1 || respJson.Data == "" {
+		t.Fatalf("unable to start session: %s", respJson.Message)
+	}
+	token := respJson.Data
+
+	// Posting with the token twice, only the first should succeed
+	for i, isValid := range []bool{true, false} {
+		post := tp.Post{Title: fmt.Sprintf("test session post %d", i),
+			Author: "sessions", Contents: "no hash here", Tag: 3}
+		jsonBody, _ = json.Marshal(post)
+		req, _ := http.NewRequest("POST", fmt.Sprintf(
+			"%s/data/post", testServer.URL), bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("unable to make post with session token")
+		}
+		respData, _ = io.ReadAll(resp.Body)
+		respJson = PostResponse{}
+		json.Unmarshal(respData, &respJson)
+
+		if isValid && respJson.Marker != 1 {
+			t.Fatalf("session post failed: %s", respJson.Message)
+		} else if !isValid && respJson.Marker != 0 {
+			t.Fatal("session token was not revoked after the chain advanced")
+		}
+
+		// New passcode is still encrypted with the hash the token stands for
+		if isValid {
+			passcode, _ := x.DecryptCipher(latestHash, respJson.Data)
+			if len(passcode) != 12 {
+				t.Fatalf("unable to decrypt passcode from session post")
+			}
+			passHashes = append(passHashes, x.RawToHash(passcode))
+		}
+	}
+}
+
+/* Checks that the current holder can rotate their passcode up to the limit,
+after which the old passcode is useless and the new one can post */
+func TestRotatePasscode(t *testing.T) {
+	if len(passHashes) < 5 {
+		TestAddPostSuccess(t)
+	}
+
+	// Rotating twice succeeds, then the configured limit is reached
+	for i, isValid := range []bool{true, true, false} {
+		oldHash := passHashes[len(passHashes)-1]
+		jsonBody, _ := json.Marshal(map[string]string{"hash": oldHash})
+		resp, err := http.Post(fmt.Sprintf("%s/data/rotate", testServer.URL),
+			"application/json", bytes.NewBuffer(jsonBody))
+		if err != nil {
+			t.Fatal("unable to rotate passcode")
+		}
+		respData, _ := io.ReadAll(resp.Body)
+		respJson = PostResponse{}
+		json.Unmarshal(respData, &respJson)
+
+		if !isValid {
+			if respJson.Marker != 0 {
+				t.Fatal("rotation beyond the limit succeeded")
+			}
+			break
+		} else if respJson.Marker != 1 {
+			t.Fatalf("rotation %d failed: %s", i, respJson.Message)
+		}
+		passcode, _ := x.DecryptCipher(oldHash, respJson.Data)
+		if len(passcode) != 12 {
+			t.Fatal("unable to decrypt rotated passcode")
+		}
+		passHashes[len(passHashes)-1] = x.RawToHash(passcode)
+	}
+
+	// A previous holder cannot rotate
+	jsonBody, _ := json.Marshal(
+		map[string]string{"hash": passHashes[len(passHashes)-2]})
+	resp, _ := http.Post(fmt.Sprintf("%s/data/rotate", testServer.URL),
+		"application/json", bytes.NewBuffer(jsonBody))
+	respData, _ := io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &respJson)
+	if respJson.Marker != 0 {
+		t.Fatal("previous holder was able to rotate the head passcode")
+	}
+
+	// The rotated passcode can post as the head of the chain
+	post := tp.Post{Title: "test rotated post", Author: "rotator",
+		Contents: "fresh passcode", Tag: 2,
+		Hash: passHashes[len(passHashes)-1]}
+	jsonBody, _ = json.Marshal(post)
+	resp, _ = http.Post(fmt.Sprintf("%s/data/post", testServer.URL),
+		"application/json", bytes.NewBuffer(jsonBody))
+	respData, _ = io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &respJson)
+	if respJson.Marker != 1 {
+		t.Fatalf("unable to post with rotated passcode: %s", respJson.Message)
+	}
+	passcode, _ := x.DecryptCipher(passHashes[len(passHashes)-1], respJson.Data)
+	passHashes = append(passHashes, x.RawToHash(passcode))
+}
+
+/* Checks that the next passcode can be split between a group, rebuilt from
+any two of three shares, and then used to post */
+func TestSharedPasscode(t *testing.T) {
+	if len(passHashes) < 5 {
+		TestAddPostSuccess(t)
+	}
+	latestHash := passHashes[len(passHashes)-1]
+	var sharedResp struct {
+		Message string   `json:"message"`
+		Marker  int      `json:"marker"`
+		Shares  []string `json:"shares"`
+	}
+
+	// Posting with the next passcode split into 3 shares
+	post := tp.Post{Title: "test shared post", Author: "team",
+		Contents: "one for all", Tag: 4, Hash: latestHash, Shares: 3,
+		Threshold: 2}
+	jsonBody, _ := json.Marshal(post)
+	resp, err := http.Post(fmt.Sprintf("%s/data/post", testServer.URL),
+		"application/json", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		t.Fatal("unable to make shared post")
+	}
+	respData, _ := io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &sharedResp)
+	if sharedResp.Marker != 1 || len(sharedResp.Shares) != 3 {
+		t.Fatalf("unexpected shared post response: %s", sharedResp.Message)
+	}
+
+	// Rebuilding the passcode from the last two shares
+	var shares []string
+	for _, ciphercode := range sharedResp.Shares[1:] {
+		share, _ := x.DecryptCipher(latestHash, ciphercode)
+		shares = append(shares, share)
+	}
+	jsonBody, _ = json.Marshal(map[string][]string{"shares": shares})
+	resp, err = http.Post(fmt.Sprintf("%s/data/combine", testServer.URL),
+		"application/json", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		t.Fatal("unable to combine shares")
+	}
+	respData, _ = io.ReadAll(resp.Body)
+	respJson = PostResponse{}
+	json.Unmarshal(respData, &respJson)
+	if respJson.Marker != 1 || len(respJson.Data) != 12 {
+		t.Fatalf("unable to rebuild passcode: %s", respJson.Message)
+	}
+	passHashes = append(passHashes, x.RawToHash(respJson.Data))
+
+	// The rebuilt passcode is validated as usual
+	post = tp.Post{Title: "test post after sharing", Author: "team",
+		Contents: "all for one", Tag: 5, Hash: passHashes[len(passHashes)-1]}
+	jsonBody, _ = json.Marshal(post)
+	resp, _ = http.Post(fmt.Sprintf("%s/data/post", testServer.URL),
+		"application/json", bytes.NewBuffer(jsonBody))
+	respData, _ = io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &respJson)
+	if respJson.Marker != 1 {
+		t.Fatalf("unable to post with rebuilt passcode: %s", respJson.Message)
+	}
+	passcode, _ := x.DecryptCipher(passHashes[len(passHashes)-1], respJson.Data)
+	passHashes = append(passHashes, x.RawToHash(passcode))
+
+	// A single share is not enough
+	jsonBody, _ = json.Marshal(map[string][]string{"shares": shares[:1]})
+	resp, _ = http.Post(fmt.Sprintf("%s/data/combine", testServer.URL),
+		"application/json", bytes.NewBuffer(jsonBody))
+	respData, _ = io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &respJson)
+	if respJson.Marker != 0 {
+		t.Fatal("passcode was rebuilt from a single share")
+	}
+}
+
+/* Checks that a note left when posting can be read with the next passcode,
+and by nobody else */
+func TestHolderNote(t *testing.T) {
+	if len(passHashes) < 5 {
+		TestAddPostSuccess(t)
+	}
+	note := "you always ask the right questions"
+
+	// Posting with a note for the next holder
+	post := tp.Post{Title: "test post with note", Author: "tester",
+		Contents: "a word in your ear", Tag: 2,
+		Hash: passHashes[len(passHashes)-1], Note: note}
+	jsonBody, _ := json.Marshal(post)
+	resp, err := http.Post(fmt.Sprintf("%s/data/post", testServer.URL),
+		"application/json", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		t.Fatal("unable to make post with note")
+	}
+	respData, _ := io.ReadAll(resp.Body)
+	respJson = PostResponse{}
+	json.Unmarshal(respData, &respJson)
+	if respJson.Marker != 1 {
+		t.Fatalf("unable to post with note: %s", respJson.Message)
+	}
+	passcode, _ := x.DecryptCipher(passHashes[len(passHashes)-1], respJson.Data)
+	passHashes = append(passHashes, x.RawToHash(passcode))
+
+	// Only the new passcode reads the note
+	for _, proof := range []string{passcode, "wrongpasscod", ""} {
+		jsonBody, _ = json.Marshal(map[string]string{"passcode": proof})
+		resp, err = http.Post(fmt.Sprintf("%s/data/note", testServer.URL),
+			"application/json", bytes.NewBuffer(jsonBody))
+		if err != nil {
+			t.Fatal("unable to request note")
+		}
+		respData, _ = io.ReadAll(resp.Body)
+		respJson = PostResponse{}
+		json.Unmarshal(respData, &respJson)
+		isHolder := proof == passcode
+		if isHolder != (respJson.Marker == 1 && respJson.Data == note) {
+			t.Logf("unexpected note response for %q: %s", proof,
+				respJson.Message)
+			t.Fail()
+		}
+	}
+
+	// Rotating the passcode discards the note, and says so
+	oldHash := passHashes[len(passHashes)-1]
+	jsonBody, _ = json.Marshal(map[string]string{"hash": oldHash})
+	resp, _ = http.Post(fmt.Sprintf("%s/data/rotate", testServer.URL),
+		"application/json", bytes.NewBuffer(jsonBody))
+	respData, _ = io.ReadAll(resp.Body)
+	respJson = PostResponse{}
+	json.Unmarshal(respData, &respJson)
+	passcode, _ = x.DecryptCipher(oldHash, respJson.Data)
+	if respJson.Marker != 1 || len(passcode) != 12 {
+		t.Fatalf("unable to rotate passcode with note: %s", respJson.Message)
+	}
+	passHashes[len(passHashes)-1] = x.RawToHash(passcode)
+
+	jsonBody, _ = json.Marshal(map[string]string{"passcode": passcode})
+	resp, _ = http.Post(fmt.Sprintf("%s/data/note", testServer.URL),
+		"application/json", bytes.NewBuffer(jsonBody))
+	respData, _ = io.ReadAll(resp.Body)
+	respJson = PostResponse{}
+	json.Unmarshal(respData, &respJson)
+	if respJson.Marker != 0 || respJson.Message != x.ErrNoteRotated.Error() {
+		t.Logf("expected rotated note error, found %s", respJson.Message)
+		t.Fail()
+	}
+}
+
+/* Checks that a sealed post is a link in the chain with only its title shown,
+and cannot be reacted to until it is revealed */
+func TestSealedPost(t *testing.T) {
+	if len(passHashes) < 5 {
+		TestAddPostSuccess(t)
+	}
+
+	// A reveal time in the past is refused
+	past := time.Now().Add(-time.Hour)
+	post := tp.Post{Title: "test sealed post", Author: "tester",
+		Contents: "open me later", Tag: 3,
+		Hash: passHashes[len(passHashes)-1], Reveal: &past}
+	jsonBody, _ := json.Marshal(post)
+	resp, _ := http.Post(fmt.Sprintf("%s/data/post", testServer.URL),
+		"application/json", bytes.NewBuffer(jsonBody))
+	respData, _ := io.ReadAll(resp.Body)
+	respJson = PostResponse{}
+	json.Unmarshal(respData, &respJson)
+	if respJson.Marker != 0 {
+		t.Fatal("post was sealed until a time in the past")
+	}
+
+	// Sealing the post for a day
+	future := time.Now().Add(time.Hour * 24)
+	post.Reveal = &future
+	jsonBody, _ = json.Marshal(post)
+	resp, _ = http.Post(fmt.Sprintf("%s/data/post", testServer.URL),
+		"application/json", bytes.NewBuffer(jsonBody))
+	respData, _ = io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &respJson)
+	if respJson.Marker != 1 {
+		t.Fatalf("unable to make sealed post: %s", respJson.Message)
+	}
+	passcode, _ := x.DecryptCipher(passHashes[len(passHashes)-1], respJson.Data)
+	passHashes = append(passHashes, x.RawToHash(passcode))
+
+	// Only the title of the sealed post is shown
+	var chainResp GetResponse
+	resp, _ = http.Get(fmt.Sprintf("%s/data/chain", testServer.URL))
+	respData, _ = io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &chainResp)
+	sealed := chainResp.Chain[0]
+	if !sealed.Sealed || sealed.Title != post.Title ||
+		sealed.Contents != u.SEALED_PLACEHOLDER || sealed.Author != "" ||
+		sealed.Descriptors != "" {
+		t.Fatalf("sealed post was not hidden: %+v", sealed)
+	}
+	resp, _ = http.Get(fmt.Sprintf("%s/html/chain", testServer.URL))
+	respData, _ = io.ReadAll(resp.Body)
+	if strings.Contains(string(respData), post.Contents) ||
+		!strings.Contains(string(respData), u.SEALED_PLACEHOLDER) {
+		t.Log("sealed post was not hidden in html chain")
+		t.Fail()
+	}
+
+	// Neither its descriptors nor reactions are available
+	resp, _ = http.Get(
+		fmt.Sprintf("%s/html/reaction/%d", testServer.URL, sealed.Id))
+	if resp.StatusCode != 400 {
+		t.Log("descriptors of sealed post were shown")
+		t.Fail()
+	}
+	addReaction(false, t, sealed.Id, "sealed", "")
+}
+
+/* Checks that a private chain can only be read with an active read token,
+given as a header or cookie, and that revoked tokens stop working */
+func TestPrivateChain(t *testing.T) {
+	os.Setenv("CHAIN_SECRET", "test6chain9secret0")
+	defer os.Setenv("CHAIN_SECRET", "")
+	var tokenResp struct {
+		Data   string `json:"data"`
+		Id     int    `json:"id"`
+		Marker int    `json:"marker"`
+	}
+
+	// Issuing a read token as admin
+	req, _ := http.NewRequest(
+		"POST", fmt.Sprintf("%s/admin/read-tokens", testServer.URL), nil)
+	req.Header.Set("X-Admin-Key", os.Getenv("ADMIN_KEY"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("unable to issue read token")
+	}
+	respData, _ := io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &tokenResp)
+	if tokenResp.Marker != 1 || tokenResp.Data == "" {
+		t.Fatal("unexpected read token response")
+	}
+
+	// Reading the chain without, with a wrong and with the issued token
+	for _, path := range []string{
+		"/data/chain", "/html/chain", "/html/reaction/1"} {
+		for token, status := range map[string]int{"": 401,
+			"wrong6read9token0": 401, tokenResp.Data: 200} {
+			req, _ = http.NewRequest("GET", testServer.URL+path, nil)
+			req.Header.Set("X-Read-Token", token)
+			resp, err = http.DefaultClient.Do(req)
+			if err != nil || resp.StatusCode != status {
+				t.Logf("expected %d reading %s with %q, found %d", status,
+					path, token, resp.StatusCode)
+				t.Fail()
+			}
+		}
+	}
+
+	// The token can instead be kept in a cookie
+	jsonBody, _ := json.Marshal(map[string]string{"token": tokenResp.Data})
+	resp, _ = http.Post(fmt.Sprintf("%s/data/read", testServer.URL),
+		"application/json", bytes.NewBuffer(jsonBody))
+	cookies := resp.Cookies()
+	if len(cookies) != 1 || cookies[0].Name != r.READ_COOKIE {
+		t.Fatal("read token cookie not set")
+	}
+	req, _ = http.NewRequest("GET", testServer.URL+"/data/chain", nil)
+	req.AddCookie(cookies[0])
+	if resp, _ = http.DefaultClient.Do(req); resp.StatusCode != 200 {
+		t.Logf("unable to read chain with cookie, found %d", resp.StatusCode)
+		t.Fail()
+	}
+
+	// Revoking the token
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("%s/admin/read-tokens/%d",
+		testServer.URL, tokenResp.Id), nil)
+	req.Header.Set("X-Admin-Key", os.Getenv("ADMIN_KEY"))
+	if resp, _ = http.DefaultClient.Do(req); resp.StatusCode != 200 {
+		t.Fatalf("unable to revoke read token, found %d", resp.StatusCode)
+	}
+	req, _ = http.NewRequest("GET", testServer.URL+"/data/chain", nil)
+	req.Header.Set("X-Read-Token", tokenResp.Data)
+	if resp, _ = http.DefaultClient.Do(req); resp.StatusCode != 401 {
+		t.Logf("revoked token read chain, found %d", resp.StatusCode)
+		t.Fail()
+	}
+}
+
+/* Checks that the author of a post can erase it, leaving a tombstone in its
+place, while nobody else can */
+func TestErasePost(t *testing.T) {
+	if len(passHashes) < 5 {
+		TestAddPostSuccess(t)
+	}
+	authorHash := passHashes[len(passHashes)-1]
+
+	// Making a post then reacting to it
+	post := tp.Post{Title: "test post to erase", Author: "regretful",
+		Contents: "i should not have said this", Tag: 6, Hash: authorHash}
+	jsonBody, _ := json.Marshal(post)
+	resp, _ := http.Post(fmt.Sprintf("%s/data/post", testServer.URL),
+		"application/json", bytes.NewBuffer(jsonBody))
+	respData, _ := io.ReadAll(resp.Body)
+	respJson = PostResponse{}
+	json.Unmarshal(respData, &respJson)
+	if respJson.Marker != 1 {
+		t.Fatalf("unable to make post to erase: %s", respJson.Message)
+	}
+	passcode, _ := x.DecryptCipher(authorHash, respJson.Data)
+	passHashes = append(passHashes, x.RawToHash(passcode))
+
+	var chainResp GetResponse
+	resp, _ = http.Get(fmt.Sprintf("%s/data/chain", testServer.URL))
+	respData, _ = io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &chainResp)
+	posted := chainResp.Chain[0]
+	addReaction(true, t, posted.Id,
+		strings.Split(posted.Descriptors, ";")[0], "")
+
+	// Only the authorising passcode can erase the post
+	for _, hash := range []string{passHashes[len(passHashes)-1], authorHash} {
+		isAuthor := hash == authorHash
+		jsonBody, _ = json.Marshal(
+			map[string]interface{}{"postId": posted.Id, "hash": hash})
+		resp, _ = http.Post(fmt.Sprintf("%s/data/erase", testServer.URL),
+			"application/json", bytes.NewBuffer(jsonBody))
+		respData, _ = io.ReadAll(resp.Body)
+		json.Unmarshal(respData, &respJson)
+		if isAuthor != (respJson.Marker == 1) {
+			t.Fatalf("unexpected erase response: %s", respJson.Message)
+		}
+	}
+
+	// The post is a tombstone in the same place, without its descriptors or
+	// reactions
+	resp, _ = http.Get(fmt.Sprintf("%s/data/chain", testServer.URL))
+	respData, _ = io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &chainResp)
+	erased := chainResp.Chain[0]
+	if erased.Id != posted.Id || !erased.Erased || erased.Author != "" ||
+		erased.Contents != u.ERASED_PLACEHOLDER || erased.Descriptors != "" ||
+		!erased.Time.Equal(posted.Time) || len(erased.Reactions) != 0 {
+		t.Logf("post not erased as expected: %+v", erased)
+		t.Fail()
+	}
+
+	// The passcode that the genesis post claimed to be authorised by was not
+	// stored, so its holder cannot erase the genesis post
+	genesis := chainResp.Chain[len(chainResp.Chain)-1]
+	jsonBody, _ = json.Marshal(
+		map[string]interface{}{"postId": genesis.Id, "hash": passHashes[1]})
+	resp, _ = http.Post(fmt.Sprintf("%s/data/erase", testServer.URL),
+		"application/json", bytes.NewBuffer(jsonBody))
+	respData, _ = io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &respJson)
+	if genesis.PasscodeId != 0 || respJson.Marker != 0 {
+		t.Logf("genesis post took a forged passcode id: %d, %s",
+			genesis.PasscodeId, respJson.Message)
+		t.Fail()
+	}
+}
+
+/* Checks that each post records which candidate made it, shown as its
+provenance and counted in the chain stats */
+func TestChainProvenance(t *testing.T) {
+	if len(passHashes) < 5 {
+		TestAddPostSuccess(t)
+	}
+	var chainResp struct {
+		Marker int           `json:"marker"`
+		Chain  []tp.Post     `json:"chain"`
+		Stats  tp.ChainStats `json:"stats"`
+	}
+	resp, err := http.Get(fmt.Sprintf("%s/data/chain", testServer.URL))
+	if err != nil {
+		t.Fatal("unable to get chain")
+	}
+	respData, _ := io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &chainResp)
+
+	// Every test post is made by the current holder, except genesis
+	genesis := chainResp.Chain[len(chainResp.Chain)-1]
+	if genesis.HashIndex != -1 || genesis.Role != "" ||
+		genesis.Provenance != "" {
+		t.Logf("unexpected genesis provenance: %+v", genesis)
+		t.Fail()
+	}
+	for _, post := range chainResp.Chain[:len(chainResp.Chain)-1] {
+		if post.HashIndex != 0 || post.Role != tp.ROLE_HEAD ||
+			post.Provenance != "passed on" || post.PasscodeId == 0 {
+			t.Logf("unexpected provenance of post %d: %+v", post.Id, post)
+			t.Fail()
+		}
+	}
+	if chainResp.Stats.Links != len(chainResp.Chain)-1 ||
+		chainResp.Stats.PassedOn != chainResp.Stats.Links {
+		t.Logf("unexpected chain stats: %+v", chainResp.Stats)
+		t.Fail()
+	}
+
+	resp, _ = http.Get(fmt.Sprintf("%s/html/chain", testServer.URL))
+	respData, _ = io.ReadAll(resp.Body)
+	if !strings.Contains(string(respData), "passed on") {
+		t.Log("provenance badge missing from html chain")
+		t.Fail()
+	}
+}
+
+/* Checks that the previous holder can only post straight away once the
+current holder has forfeited, and that the forfeit is shown on the chain */
+func TestForfeit(t *testing.T) {
+	if len(passHashes) < 5 {
+		TestAddPostSuccess(t)
+	}
+	headHash := passHashes[len(passHashes)-1]
+	previousHash := passHashes[len(passHashes)-2]
+	post := tp.Post{Title: "test rescue post", Author: "rescuer",
+		Contents: "i will take it from here", Tag: 1, Hash: previousHash}
+	jsonBody, _ := json.Marshal(post)
+
+	// The previous holder is outside their window
+	resp, _ := http.Post(fmt.Sprintf("%s/data/post", testServer.URL),
+		"application/json", bytes.NewBuffer(jsonBody))
+	respData, _ := io.ReadAll(resp.Body)
+	respJson = PostResponse{}
+	json.Unmarshal(respData, &respJson)
+	if respJson.Marker != 0 {
+		t.Fatal("previous holder posted before forfeit")
+	}
+
+	// Only the current holder can forfeit, and only once
+	forfeits := []struct {
+		hash   string
+		status int
+	}{{previousHash, 400}, {headHash, 201}, {headHash, 400}}
+	for _, forfeit := range forfeits {
+		proof, _ := json.Marshal(map[string]string{"hash": forfeit.hash})
+		resp, _ = http.Post(fmt.Sprintf("%s/data/forfeit", testServer.URL),
+			"application/json", bytes.NewBuffer(proof))
+		if resp.StatusCode != forfeit.status {
+			t.Fatalf("expected forfeit status %d, found %d", forfeit.status,
+				resp.StatusCode)
+		}
+	}
+
+	// Now the previous holder can rescue the chain
+	resp, _ = http.Post(fmt.Sprintf("%s/data/post", testServer.URL),
+		"application/json", bytes.NewBuffer(jsonBody))
+	respData, _ = io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &respJson)
+	if respJson.Marker != 1 {
+		t.Fatalf("previous holder unable to post after forfeit: %s",
+			respJson.Message)
+	}
+	passcode, _ := x.DecryptCipher(previousHash, respJson.Data)
+	passHashes = append(passHashes, x.RawToHash(passcode))
+
+	var chainResp GetResponse
+	resp, _ = http.Get(fmt.Sprintf("%s/data/chain", testServer.URL))
+	respData, _ = io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &chainResp)
+	rescue, forfeited := chainResp.Chain[0], chainResp.Chain[1]
+	if rescue.HashIndex != 1 || rescue.Role != tp.ROLE_RECENT ||
+		forfeited.Forfeited == nil {
+		t.Logf("forfeit not shown on chain: %+v, %+v", rescue, forfeited)
+		t.Fail()
+	}
+}
+
+/* Tests that a post can branch into several passcodes, each continuing its
+own branch from the post, which is shown in the tree */
+func TestBranchingPost(t *testing.T) {
+	if len(passHashes) < 5 {
+		TestAddPostSuccess(t)
+	}
+	latestHash := passHashes[len(passHashes)-1]
+	var branchResp struct {
+		Message  string   `json:"message"`
+		Marker   int      `json:"marker"`
+		Branches []string `json:"branches"`
+	}
+
+	// Branching into two passcodes, which cannot be combined with shares
+	invalid := tp.Post{Title: "test invalid branch", Author: "forker",
+		Contents: "too many", Tag: 3, Hash: latestHash, Branches: 2,
+		Shares: 3, Threshold: 2}
+	jsonBody, _ := json.Marshal(invalid)
+	resp, _ := http.Post(fmt.Sprintf("%s/data/post", testServer.URL),
+		"application/json", bytes.NewBuffer(jsonBody))
+	if resp.StatusCode != 400 {
+		t.Fatalf("expected branches with shares to fail, found %d",
+			resp.StatusCode)
+	}
+	post := tp.Post{Title: "test branching post", Author: "forker",
+		Contents: "two roads diverged", Tag: 3, Hash: latestHash, Branches: 2}
+	jsonBody, _ = json.Marshal(post)
+	resp, _ = http.Post(fmt.Sprintf("%s/data/post", testServer.URL),
+		"application/json", bytes.NewBuffer(jsonBody))
+	respData, _ := io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &branchResp)
+	if branchResp.Marker != 1 || len(branchResp.Branches) != 2 {
+		t.Fatalf("unexpected branching post response: %s", branchResp.Message)
+	}
+
+	// Each passcode continues its own branch, the first being the trunk
+	titles := []string{"test trunk post", "test side branch post"}
+	var branchHashes, firstHashes []string
+	for i, ciphercode := range branchResp.Branches {
+		passcode, _ := x.DecryptCipher(latestHash, ciphercode)
+		hash := x.RawToHash(passcode)
+		firstHashes = append(firstHashes, hash)
+		post := tp.Post{Title: titles[i], Author: "walker",
+			Contents: "the road taken", Tag: 5, Hash: hash}
+		jsonBody, _ = json.Marshal(post)
+		resp, _ = http.Post(fmt.Sprintf("%s/data/post", testServer.URL),
+			"application/json", bytes.NewBuffer(jsonBody))
+		respData, _ = io.ReadAll(resp.Body)
+		respJson = PostResponse{}
+		json.Unmarshal(respData, &respJson)
+		if respJson.Marker != 1 {
+			t.Fatalf("unable to post on branch %d: %s", i, respJson.Message)
+		}
+		passcode, _ = x.DecryptCipher(hash, respJson.Data)
+		branchHashes = append(branchHashes, x.RawToHash(passcode))
+	}
+	passHashes = append(passHashes, branchHashes[0])
+
+	// Both branch posts have the branching post as their parent
+	var chainResp GetResponse
+	resp, _ = http.Get(fmt.Sprintf("%s/data/chain", testServer.URL))
+	respData, _ = io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &chainResp)
+	side, trunk, parent := chainResp.Chain[0], chainResp.Chain[1],
+		chainResp.Chain[2]
+	if trunk.Branch != 0 || side.Branch == 0 ||
+		trunk.ParentId != parent.Id || side.ParentId != parent.Id {
+		t.Fatalf("unexpected branches: %+v, %+v", trunk, side)
+	}
+
+	// The tree holds both branch posts under the branching post
+	var treeResp struct {
+		Marker int           `json:"marker"`
+		Tree   []tp.PostNode `json:"tree"`
+	}
+	resp, _ = http.Get(fmt.Sprintf("%s/data/tree", testServer.URL))
+	respData, _ = io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &treeResp)
+	node := treeResp.Tree[0]
+	for node.Post.Id != parent.Id && len(node.Children) == 1 {
+		node = node.Children[0]
+	}
+	if node.Post.Id != parent.Id || len(node.Children) != 2 {
+		t.Logf("branching post not found with 2 children: %+v", node.Post)
+		t.Fail()
+	}
+
+	// Once the side branch moves on, its own first passcode is its genesis
+	headHash := branchHashes[1]
+	for i := 0; i < 3; i++ {
+		post := tp.Post{Title: fmt.Sprintf("test side branch post %d", i),
+			Author: "walker", Contents: "further on", Tag: 5, Hash: headHash}
+		jsonBody, _ = json.Marshal(post)
+		resp, _ = http.Post(fmt.Sprintf("%s/data/post", testServer.URL),
+			"application/json", bytes.NewBuffer(jsonBody))
+		respData, _ = io.ReadAll(resp.Body)
+		respJson = PostResponse{}
+		json.Unmarshal(respData, &respJson)
+		if respJson.Marker != 1 {
+			t.Fatalf("unable to post on side branch: %s", respJson.Message)
+		}
+		passcode, _ := x.DecryptCipher(headHash, respJson.Data)
+		headHash = x.RawToHash(passcode)
+	}
+	var statusResp struct {
+		Marker int               `json:"marker"`
+		Status tp.PasscodeStatus `json:"status"`
+	}
+	proof, _ := json.Marshal(map[string]string{"hash": firstHashes[1]})
+	resp, _ = http.Post(fmt.Sprintf("%s/data/status", testServer.URL),
+		"application/json", bytes.NewBuffer(proof))
+	respData, _ = io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &statusResp)
+	if statusResp.Marker != 1 || statusResp.Status.Role != tp.ROLE_GENESIS ||
+		statusResp.Status.Branch != side.Branch {
+		t.Logf("unexpected status of the side branch's genesis: %s", respData)
+		t.Fail()
+	}
+}
+
+/* Tests that the current holder and an admin can fork new chains from earlier
+posts, which are listed in the fork graph and link back to their source */
+func TestForkChain(t *testing.T) {
+	if len(passHashes) < 5 {
+		TestAddPostSuccess(t)
+	}
+	headHash := passHashes[len(passHashes)-1]
+	var chainResp GetResponse
+	resp, _ := http.Get(fmt.Sprintf("%s/data/chain", testServer.URL))
+	respData, _ := io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &chainResp)
+	source := chainResp.Chain[len(chainResp.Chain)-2]
+	var forkResp struct {
+		Message string  `json:"message"`
+		Marker  int     `json:"marker"`
+		Data    string  `json:"data"`
+		Fork    tp.Fork `json:"fork"`
+	}
+
+	// Only the current holder can fork, here from an early post
+	for _, hash := range []string{passHashes[len(passHashes)-2], headHash} {
+		proof, _ := json.Marshal(
+			map[string]interface{}{"postId": source.Id, "hash": hash})
+		resp, _ = http.Post(fmt.Sprintf("%s/data/fork", testServer.URL),
+			"application/json", bytes.NewBuffer(proof))
+		respData, _ = io.ReadAll(resp.Body)
+		json.Unmarshal(respData, &forkResp)
+	}
+	if forkResp.Marker != 1 || forkResp.Fork.SourcePostId != source.Id {
+		t.Fatalf("unable to fork as current holder: %s", forkResp.Message)
+	}
+
+	// The genesis passcode of the fork makes its first post
+	passcode, _ := x.DecryptCipher(headHash, forkResp.Data)
+	post := tp.Post{Title: "test forked post", Author: "tangent",
+		Contents: "meanwhile, elsewhere", Tag: 2, Hash: x.RawToHash(passcode)}
+	jsonBody, _ := json.Marshal(post)
+	resp, _ = http.Post(fmt.Sprintf("%s/data/post", testServer.URL),
+		"application/json", bytes.NewBuffer(jsonBody))
+	respData, _ = io.ReadAll(resp.Body)
+	respJson = PostResponse{}
+	json.Unmarshal(respData, &respJson)
+	if respJson.Marker != 1 {
+		t.Fatalf("unable to post on fork: %s", respJson.Message)
+	}
+
+	// The fork is its own chain, continuing from the source post
+	chainResp = GetResponse{}
+	resp, _ = http.Get(fmt.Sprintf("%s/data/chain?chain=%d", testServer.URL,
+		forkResp.Fork.Id))
+	respData, _ = io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &chainResp)
+	if len(chainResp.Chain) != 1 || chainResp.Chain[0].Title != post.Title ||
+		chainResp.Chain[0].ParentId != source.Id {
+		t.Fatalf("unexpected forked chain: %+v", chainResp.Chain)
+	}
+	forkedPost := chainResp.Chain[0]
+
+	// An admin can fork from the fork, without a passcode
+	req, _ := http.NewRequest("POST", fmt.Sprintf("%s/admin/forks/%d",
+		testServer.URL, forkedPost.Id), nil)
+	req.Header.Set("X-Admin-Key", os.Getenv("ADMIN_KEY"))
+	resp, _ = http.DefaultClient.Do(req)
+	respData, _ = io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &forkResp)
+	if resp.StatusCode != 201 || len(forkResp.Data) != 12 {
+		t.Fatalf("unable to fork as admin: %s", forkResp.Message)
+	}
+
+	// The fork graph names the chain each fork came from
+	var forksResp struct {
+		Forks []tp.Fork `json:"forks"`
+	}
+	resp, _ = http.Get(fmt.Sprintf("%s/data/forks", testServer.URL))
+	respData, _ = io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &forksResp)
+	forks := forksResp.Forks
+	if len(forks) != 2 || forks[0].SourceChain != 0 ||
+		forks[1].SourceChain != forks[0].Id ||
+		forks[1].Authority != x.FORK_ADMIN {
+		t.Logf("unexpected fork graph: %+v", forks)
+		t.Fail()
+	}
+}
+
+/* Tests that a fork can choose its own word list, which the descriptors of
+its posts are then picked from */
+func TestForkWordList(t *testing.T) {
+	if len(passHashes) < 5 {
+		TestAddPostSuccess(t)
+	}
+	birdWords := "robin wren thrush finch sparrow starling blackbird " +
+		"magpie jay swift swallow"
+	birds, _ := w.ParseList(
+		"birds", []byte(strings.ReplaceAll(birdWords, " ", "\n")))
+	w.Register(birds)
+	var forkResp struct {
+		Message string  `json:"message"`
+		Data    string  `json:"data"`
+		Fork    tp.Fork `json:"fork"`
+	}
+
+	// Only lists that have been loaded can be chosen
+	for _, wordList := range []string{"missing", "birds"} {
+		choice, _ := json.Marshal(map[string]string{"wordList": wordList})
+		req, _ := http.NewRequest("POST", fmt.Sprintf("%s/admin/forks/1",
+			testServer.URL), bytes.NewBuffer(choice))
+		req.Header.Set("X-Admin-Key", os.Getenv("ADMIN_KEY"))
+		resp, _ := http.DefaultClient.Do(req)
+		respData, _ := io.ReadAll(resp.Body)
+		json.Unmarshal(respData, &forkResp)
+		if (resp.StatusCode == 201) != (wordList == "birds") {
+			t.Fatalf("unexpected fork with %s list: %s", wordList,
+				forkResp.Message)
+		}
+	}
+
+	post := tp.Post{Title: "test bird post", Author: "birder",
+		Contents: "a wren at dawn", Tag: 3, Hash: x.RawToHash(forkResp.Data)}
+	jsonBody, _ := json.Marshal(post)
+	resp, _ := http.Post(fmt.Sprintf("%s/data/post", testServer.URL),
+		"application/json", bytes.NewBuffer(jsonBody))
+	respData, _ := io.ReadAll(resp.Body)
+	respJson = PostResponse{}
+	json.Unmarshal(respData, &respJson)
+	if respJson.Marker != 1 {
+		t.Fatalf("unable to post on fork: %s", respJson.Message)
+	}
+
+	var chainResp GetResponse
+	resp, _ = http.Get(fmt.Sprintf("%s/data/chain?chain=%d", testServer.URL,
+		forkResp.Fork.Id))
+	respData, _ = io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &chainResp)
+	for _, descriptor := range strings.Split(
+		chainResp.Chain[0].Descriptors, ";") {
+		if !strings.Contains(birdWords, descriptor) {
+			t.Logf("descriptor %q not from the fork's list", descriptor)
+			t.Fail()
+		}
+	}
+}
+
+/* Tests that an active chain cannot be revived, even with a revival token
+issued by an admin */
+func TestRevival(t *testing.T) {
+	if len(passHashes) < 5 {
+		TestAddPostSuccess(t)
+	}
+	resp, _ := http.Get(fmt.Sprintf("%s/data/revival", testServer.URL))
+	if resp.StatusCode != 400 {
+		t.Fatalf("expected no challenge for active chain, found %d",
+			resp.StatusCode)
+	}
+
+	// Issuing a revival token as admin
+	req, _ := http.NewRequest(
+		"POST", fmt.Sprintf("%s/admin/revival-tokens", testServer.URL), nil)
+	req.Header.Set("X-Admin-Key", os.Getenv("ADMIN_KEY"))
+	resp, _ = http.DefaultClient.Do(req)
+	respData, _ := io.ReadAll(resp.Body)
+	respJson = PostResponse{}
+	json.Unmarshal(respData, &respJson)
+	if resp.StatusCode != 201 || len(respJson.Data) != 24 {
+		t.Fatalf("unable to issue revival token: %s", respJson.Message)
+	}
+
+	// The token is refused while the chain is active
+	post := tp.Post{Title: "test revival post", Author: "reviver",
+		Contents: "rise again", Tag: 6, Hash: x.RawToHash(respJson.Data),
+		Revival: x.REVIVAL_TOKEN}
+	jsonBody, _ := json.Marshal(post)
+	resp, _ = http.Post(fmt.Sprintf("%s/data/post", testServer.URL),
+		"application/json", bytes.NewBuffer(jsonBody))
+	respData, _ = io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &respJson)
+	if respJson.Marker != 0 || respJson.Message != x.ErrNotDormant.Error() {
+		t.Logf("expected revival of active chain to fail: %s",
+			respJson.Message)
+		t.Fail()
+	}
+}
+
+/* Checks that Chain Law lists every tier of the window, and that only the
+current holder can post straight after the head post */
+func TestChainLaw(t *testing.T) {
+	if len(passHashes) < 5 {
+		TestAddPostSuccess(t)
+	}
+	var lawResp struct {
+		Marker int          `json:"marker"`
+		Head   time.Time    `json:"head"`
+		Tiers  []tp.LawTier `json:"tiers"`
+	}
+	resp, err := http.Get(fmt.Sprintf("%s/data/chain/law", testServer.URL))
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatal("unable to get chain law")
+	}
+	respData, _ := io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &lawResp)
+	if lawResp.Marker != 1 || len(lawResp.Tiers) != u.CandidateWindow() {
+		t.Fatalf("unexpected chain law: %s", respData)
+	}
+	for _, tier := range lawResp.Tiers {
+		if tier.Eligible != (tier.HashIndex == 0) {
+			t.Logf("unexpected eligibility of tier %+v", tier)
+			t.Fail()
+		}
+	}
+	if opens := lawResp.Tiers[1].OpensAt.Sub(lawResp.Head); opens !=
+		time.Hour*24*5 {
+		t.Logf("expected previous holder after 5 days, found %v", opens)
+		t.Fail()
+	}
+
+	resp, _ = http.Get(
+		fmt.Sprintf("%s/data/chain/law?branch=x", testServer.URL))
+	if resp.StatusCode != 400 {
+		t.Logf("expected an invalid branch to fail, found %d", resp.StatusCode)
+		t.Fail()
+	}
+}
+
+/* Checks that the Chain Law calendar has an event for each tier that has yet
+to open after the head post */
+func TestChainLawCalendar(t *testing.T) {
+	if len(passHashes) < 5 {
+		TestAddPostSuccess(t)
+	}
+	resp, err := http.Get(
+		fmt.Sprintf("%s/data/chain/law.ics", testServer.URL))
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatal("unable to get chain law calendar")
+	}
+	respData, _ := io.ReadAll(resp.Body)
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/calendar") {
+		t.Logf("unexpected content type %s", resp.Header.Get("Content-Type"))
+		t.Fail()
+	}
+	events := strings.Count(string(respData), "BEGIN:VEVENT")
+	if events != u.CandidateWindow()-1 ||
+		!strings.Contains(string(respData), "Exclusive window ends") {
+		t.Logf("expected an event for each waiting tier: %s", respData)
+		t.Fail()
+	}
+}
+
+/* Checks that the holder of a passcode can see what it can do, and that
+unknown passcodes reveal nothing */
+func TestPasscodeStatus(t *testing.T) {
+	if len(passHashes) < 5 {
+		TestAddPostSuccess(t)
+	}
+	var statusResp struct {
+		Marker int               `json:"marker"`
+		Status tp.PasscodeStatus `json:"status"`
+	}
+	proof, _ := json.Marshal(
+		map[string]string{"hash": passHashes[len(passHashes)-1]})
+	resp, _ := http.Post(fmt.Sprintf("%s/data/status", testServer.URL),
+		"application/json", bytes.NewBuffer(proof))
+	respData, _ := io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &statusResp)
+	status := statusResp.Status
+	if statusResp.Marker != 1 || status.Role != tp.ROLE_HEAD ||
+		!status.CanPost || len(status.Gravitas) == 0 {
+		t.Fatalf("unexpected status of the head: %s", respData)
+	}
+
+	proof, _ = json.Marshal(map[string]string{"hash": x.RawToHash("unheld")})
+	resp, _ = http.Post(fmt.Sprintf("%s/data/status", testServer.URL),
+		"application/json", bytes.NewBuffer(proof))
+	respData, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != 400 ||
+		!strings.Contains(string(respData), "passcode validation failed") {
+		t.Logf("expected an unknown passcode to fail: %s", respData)
+		t.Fail()
+	}
+
+	// Each client can only ask for so many statuses a minute
+	statusRl := r.StatusRl
+	r.StatusRl = x.NewClientLimiter(1, time.Minute)
+	defer func() { r.StatusRl = statusRl }()
+	proof, _ = json.Marshal(
+		map[string]string{"hash": passHashes[len(passHashes)-1]})
+	for _, code := range []int{200, 429} {
+		resp, _ = http.Post(fmt.Sprintf("%s/data/status", testServer.URL),
+			"application/json", bytes.NewBuffer(proof))
+		if resp.StatusCode != code {
+			t.Logf("expected status request %d, found %d", code,
+				resp.StatusCode)
+			t.Fail()
+		}
+	}
+}
+
+/* Checks that the genesis passcode of a fork takes the genesis role on its own
+branch, and can post there once the window opens to it */
+func TestForkGenesis(t *testing.T) {
+	if len(passHashes) < 5 {
+		TestAddPostSuccess(t)
+	}
+	var chainResp GetResponse
+	resp, _ := http.Get(fmt.Sprintf("%s/data/chain", testServer.URL))
+	respData, _ := io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &chainResp)
+	req, _ := http.NewRequest("POST", fmt.Sprintf("%s/admin/forks/%d",
+		testServer.URL, chainResp.Chain[0].Id), nil)
+	req.Header.Set("X-Admin-Key", os.Getenv("ADMIN_KEY"))
+	resp, _ = http.DefaultClient.Do(req)
+	respData, _ = io.ReadAll(resp.Body)
+	respJson = PostResponse{}
+	json.Unmarshal(respData, &respJson)
+	if resp.StatusCode != 201 {
+		t.Fatalf("unable to fork as admin: %s", respJson.Message)
+	}
+	genesisHash := x.RawToHash(respJson.Data)
+
+	// Posting on the fork until its genesis passcode leaves the recent slots
+	forkPost := func(hash string, i int) PostResponse {
+		post := tp.Post{Title: fmt.Sprintf("test fork genesis %d", i),
+			Author: "tangent", Contents: "far from home", Tag: 3, Hash: hash}
+		jsonBody, _ := json.Marshal(post)
+		resp, _ := http.Post(fmt.Sprintf("%s/data/post", testServer.URL),
+			"application/json", bytes.NewBuffer(jsonBody))
+		respData, _ := io.ReadAll(resp.Body)
+		var postResp PostResponse
+		json.Unmarshal(respData, &postResp)
+		return postResp
+	}
+	headHash := genesisHash
+	for i := 0; i < 5; i++ {
+		postResp := forkPost(headHash, i)
+		if postResp.Marker != 1 {
+			t.Fatalf("unable to post on fork: %s", postResp.Message)
+		}
+		passcode, _ := x.DecryptCipher(headHash, postResp.Data)
+		headHash = x.RawToHash(passcode)
+	}
+
+	// The fork's genesis passcode, not the original chain's, is its genesis
+	var statusResp struct {
+		Marker int               `json:"marker"`
+		Status tp.PasscodeStatus `json:"status"`
+	}
+	proof, _ := json.Marshal(map[string]string{"hash": genesisHash})
+	resp, _ = http.Post(fmt.Sprintf("%s/data/status", testServer.URL),
+		"application/json", bytes.NewBuffer(proof))
+	respData, _ = io.ReadAll(resp.Body)
+	json.Unmarshal(respData, &statusResp)
+	if statusResp.Marker != 1 || statusResp.Status.Role != tp.ROLE_GENESIS ||
+		statusResp.Status.CanPost {
+		t.Fatalf("unexpected status of the fork's genesis: %s", respData)
+	}
+
+	// Once the head forfeits, the fork's genesis passcode can post on it
+	proof, _ = json.Marshal(map[string]string{"hash": headHash})
+	resp, _ = http.Post(fmt.Sprintf("%s/data/forfeit", testServer.URL),
+		"application/json", bytes.NewBuffer(proof))
+	if resp.StatusCode != 201 {
+		t.Fatalf("unable to forfeit fork head, found %d", resp.StatusCode)
+	}
+	if postResp := forkPost(genesisHash, 5); postResp.Marker != 1 {
+		t.Logf("fork genesis unable to post: %s", postResp.Message)
+		t.Fail()
+	}
+}
+
 /* Adds a test reaction */
 func addReaction(
 	isValid bool, t *testing.T, postId int, descriptor, hash string) {
@@ -284,7 +1484,26 @@ func addReaction(
 
 /* Adds a genesis post to chain. Is needed for all major tests */
 func addGenesisPost(t *testing.T) {
-	// Create json request body
+	// Without the genesis token the genesis post should be refused
+	for _, hash := range []string{"", invalidHashes[1]} {
+		testPostReqBodies[0].Hash = hash
+		jsonBody, _ := json.Marshal(testPostReqBodies[0])
+		resp, err := http.Post(fmt.Sprintf("%s/data/post", testServer.URL),
+			"application/json", bytes.NewBuffer(jsonBody))
+		if err != nil {
+			t.Fatal("unable to make genesis post")
+		}
+		respData, _ := io.ReadAll(resp.Body)
+		json.Unmarshal(respData, &respJson)
+		if respJson.Marker != 0 {
+			t.Fatal("genesis post made without a genesis token")
+		}
+	}
+
+	// Create json request body, with the hash of the genesis token. It claims
+	// to be authorised by the first passcode, which should be ignored
+	testPostReqBodies[0].Hash = passHashes[0]
+	testPostReqBodies[0].PasscodeId = 1
 	jsonBody, err := json.Marshal(testPostReqBodies[0])
 	if err != nil {
 		t.Fatal("unable to marshal Post type into body")
@@ -0,0 +1,217 @@
+package routes
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"os"
+	"strconv"
+
+	x "github.com/georgejmx/whisper-blog/security"
+	tp "github.com/georgejmx/whisper-blog/types"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	DEFAULT_EVENT_LIMIT int = 50
+	MAX_EVENT_LIMIT     int = 500
+)
+
+/* Gets the most recent security events as JSON, latest first. The number of
+events can be set with the *limit* query parameter. Admin only */
+func GetSecurityEvents(c *gin.Context) {
+	Rl.Take()
+	if isLockedOut(c) || !checkAdmin(c) {
+		return
+	}
+
+	limit, ok := parseLimit(c)
+	if !ok {
+		return
+	}
+
+	events, err := dbo.SelectSecurityEvents(limit)
+	if err != nil {
+		sendFailure(c, "selecting security events database operation failed")
+		return
+	} else if events == nil {
+		events = []tp.SecurityEvent{}
+	}
+
+	c.JSON(200, gin.H{
+		"marker": 1,
+		"events": events,
+	})
+}
+
+/* Gets the most recent passcode rotations as JSON, latest first. The number of
+rotations can be set with the *limit* query parameter. Admin only */
+func GetRotations(c *gin.Context) {
+	Rl.Take()
+	if isLockedOut(c) || !checkAdmin(c) {
+		return
+	}
+	limit, ok := parseLimit(c)
+	if !ok {
+		return
+	}
+
+	rotations, err := dbo.SelectRotations(limit)
+	if err != nil {
+		sendFailure(c, "selecting rotations database operation failed")
+		return
+	} else if rotations == nil {
+		rotations = []tp.PasscodeRotation{}
+	}
+
+	c.JSON(200, gin.H{
+		"marker":    1,
+		"rotations": rotations,
+	})
+}
+
+/* Forks a new chain from the post with the id in the url. The raw genesis
+passcode of the fork is only shown in this response. Admin only */
+func AddFork(c *gin.Context) {
+	Rl.Take()
+	if isLockedOut(c) || !checkAdmin(c) {
+		return
+	}
+
+	postId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		sendFailure(c, "error parsing url parameter")
+		return
+	}
+	// The word list can optionally be chosen in the body, as {wordList}
+	var choice struct {
+		WordList string `json:"wordList"`
+	}
+	if body, err := c.GetRawData(); err != nil ||
+		(len(body) > 0 && json.Unmarshal(body, &choice) != nil) {
+		sendFailure(c, "invalid request body")
+		return
+	}
+	fork, passcode, err := x.ForkChain(
+		dbo, postId, x.FORK_ADMIN, choice.WordList)
+	if err != nil {
+		sendFailure(c, err.Error())
+		return
+	}
+
+	c.JSON(201, gin.H{
+		"message": "chain forked",
+		"data":    passcode,
+		"fork":    fork,
+		"marker":  1,
+	})
+}
+
+/* Issues a one-time revival token, which can revive a dormant chain in place
+of a proof of work. The token is only shown in this response. Admin only */
+func AddRevivalToken(c *gin.Context) {
+	Rl.Take()
+	if isLockedOut(c) || !checkAdmin(c) {
+		return
+	}
+
+	token, err := x.IssueRevivalToken(dbo)
+	if err != nil {
+		sendFailure(c, "unable to issue revival token")
+		return
+	}
+
+	c.JSON(201, gin.H{
+		"message": "revival token issued",
+		"data":    token,
+		"marker":  1,
+	})
+}
+
+/* Issues a new read token for a private chain. The token is only shown in this
+response, along with the id used to revoke it. Admin only */
+func AddReadToken(c *gin.Context) {
+	Rl.Take()
+	if isLockedOut(c) || !checkAdmin(c) {
+		return
+	}
+
+	token, id, err := x.IssueReadToken(dbo)
+	if err != nil {
+		sendFailure(c, "unable to issue read token")
+		return
+	}
+
+	c.JSON(201, gin.H{
+		"message": "read token issued",
+		"data":    token,
+		"id":      id,
+		"marker":  1,
+	})
+}
+
+/* Revokes the read token with the id in the url, without needing to
+re-encrypt any posts. Admin only */
+func DeleteReadToken(c *gin.Context) {
+	Rl.Take()
+	if isLockedOut(c) || !checkAdmin(c) {
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		sendFailure(c, "error parsing url parameter")
+		return
+	}
+	if err = x.RevokeReadToken(dbo, id); err != nil {
+		sendFailure(c, err.Error())
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message": "read token revoked",
+		"marker":  1,
+	})
+}
+
+/* Parses the optional *limit* query parameter, sending a failure response if
+it is invalid */
+func parseLimit(c *gin.Context) (int, bool) {
+	param := c.Query("limit")
+	if param == "" {
+		return DEFAULT_EVENT_LIMIT, true
+	}
+
+	limit, err := strconv.Atoi(param)
+	if err != nil || limit < 1 || limit > MAX_EVENT_LIMIT {
+		sendFailure(c, "invalid limit query parameter")
+		return 0, false
+	}
+	return limit, true
+}
+
+/* Checks the *X-Admin-Key* header against the configured admin key. Sends a
+failure response and records the attempt if they do not match. Admin routes
+are disabled when no admin key is configured */
+func checkAdmin(c *gin.Context) bool {
+	key := os.Getenv("ADMIN_KEY")
+	provided := c.GetHeader("X-Admin-Key")
+	if key == "" {
+		c.JSON(503, gin.H{
+			"message": "admin routes are disabled, no admin key is set",
+			"marker":  0,
+		})
+		return false
+	}
+
+	// Comparing hashes so that the comparison is constant time and length
+	if subtle.ConstantTimeCompare(
+		[]byte(x.RawToHash(provided)), []byte(x.RawToHash(key))) == 1 {
+		return true
+	}
+	recordFailure(c, x.ErrAdminKey)
+	c.JSON(401, gin.H{
+		"message": "admin key required",
+		"marker":  0,
+	})
+	return false
+}
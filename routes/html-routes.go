@@ -5,7 +5,6 @@ import (
 	"embed"
 	"html/template"
 	"strconv"
-	"strings"
 
 	tp "github.com/georgejmx/whisper-blog/types"
 	u "github.com/georgejmx/whisper-blog/utils"
@@ -18,8 +17,31 @@ var templateData embed.FS
 /* Gets HTML markup for the frontend chain, dependent on current backup data */
 func GetHtmlChain(c *gin.Context) {
 	Rl.Take()
+	if !checkReadToken(c) {
+		return
+	}
 	var htmlPosts []tp.PostHtmlContent
 	_, stampedPosts := getChain(c)
+	forks, err := dbo.SelectForks()
+	if err != nil {
+		sendFailure(c, "selecting forks database operation failed")
+		return
+	}
+
+	// Titles and chains of every post, so that branched and forked posts can
+	// name their parent even when it is on another chain
+	parents := make(map[int]tp.Post)
+	for _, stamped := range stampedPosts {
+		parents[stamped.Id] = stamped
+	}
+	isForkBranch := make(map[int]bool)
+	for _, fork := range forks {
+		isForkBranch[fork.Branch] = true
+	}
+	stampedPosts, ok := filterChain(c, stampedPosts)
+	if !ok {
+		return
+	}
 
 	// Converting stamped posts to html suitable types
 	for _, stamped := range stampedPosts {
@@ -39,6 +61,26 @@ func GetHtmlChain(c *gin.Context) {
 			Contents:    stamped.Contents,
 			Author:      stamped.Author,
 			Reactions:   u.AwardDescriptors(stamped.Reactions),
+			IsSealed:    stamped.Sealed,
+			Provenance:  stamped.Provenance,
+			Forks:       stamped.Forks,
+		}
+
+		// Posts of a fork link back to where it was forked from, while
+		// posts on other branches of the same chain are labelled and indented
+		parent, hasParent := parents[stamped.ParentId]
+		if hasParent && parent.Chain != stamped.Chain {
+			htmlPost.ForkedFrom = parent.Title
+			htmlPost.SourceChain = parent.Chain
+		} else if stamped.Branch != 0 && !isForkBranch[stamped.Branch] {
+			htmlPost.Branch = stamped.Branch
+			htmlPost.ParentTitle = parent.Title
+		}
+		if stamped.Sealed {
+			htmlPost.RevealTime = u.GetTimestring(*stamped.Reveal)
+		}
+		if stamped.Forfeited != nil {
+			htmlPost.Forfeited = u.GetTimestring(*stamped.Forfeited)
 		}
 
 		htmlPosts = append(htmlPosts, htmlPost)
@@ -50,7 +92,10 @@ func GetHtmlChain(c *gin.Context) {
 		sendFailure(c, "error parsing html template")
 		return
 	}
-	htmlStructure := tp.HtmlPostContainer{HtmlPosts: htmlPosts}
+	htmlStructure := tp.HtmlPostContainer{
+		HtmlPosts: htmlPosts,
+		Stats:     u.GetChainStats(stampedPosts),
+	}
 
 	// Executing template, to return byte array. Sending this to client
 	var buf bytes.Buffer
@@ -58,24 +103,50 @@ func GetHtmlChain(c *gin.Context) {
 	c.Data(200, "text/html; charset=utf-8", buf.Bytes())
 }
 
+/* Gets an iCalendar file of the upcoming Chain Law milestones of the branch
+in the branch query parameter, for holders to subscribe to. It is built from
+the current head on every request, so it follows the chain as it advances */
+func GetChainLawCalendar(c *gin.Context) {
+	Rl.Take()
+	if !checkReadToken(c) {
+		return
+	}
+	branch, headTime, isForfeited, ok := getHead(c)
+	if !ok {
+		return
+	}
+	ics := u.ChainLawCalendar(
+		branch, headTime, u.ChainLaw(headTime, isForfeited))
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Content-Disposition", "inline; filename=chain-law.ics")
+	c.Data(200, "text/calendar; charset=utf-8", []byte(ics))
+}
+
 /* Gets html reactions that will be passed to frontend */
 func GetHtmlReactions(c *gin.Context) {
 	Rl.Take()
-	attachHeaders(c)
+	if !checkReadToken(c) {
+		return
+	}
 
 	postId, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
 		sendFailure(c, "error parsing url parameter")
 		return
+	} else if isPostSealed(c, int(postId)) {
+		return
 	}
 
-	descriptorsStr, err := dbo.SelectDescriptors(int(postId))
+	postDescriptors, err := dbo.SelectPostDescriptors(int(postId))
 	if err != nil {
 		sendFailure(c, "error getting post descriptors")
 		return
 	}
 
-	descriptors := strings.Split(descriptorsStr, ";")
+	descriptors := make([]string, len(postDescriptors))
+	for i, descriptor := range postDescriptors {
+		descriptors[i] = descriptor.Word
+	}
 
 	// Getting our template, and its structure
 	t, err := template.ParseFS(templateData, "templates/descriptors.gohtml")
@@ -2,6 +2,11 @@ package routes
 
 import (
 	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	x "github.com/georgejmx/whisper-blog/security"
 	tp "github.com/georgejmx/whisper-blog/types"
@@ -16,16 +21,43 @@ const MAX_ANON_REACTIONS int = 6
 var IsTimeGuarded = false
 
 /* Gets the chain stored in backend as JSON. This inlcudes all posts and the
-top 3 reactions for each post */
+top 3 reactions for each post, or only the posts of one chain when the chain
+query parameter is given */
 func GetRawChain(c *gin.Context) {
 	// Sending success json response with chain data
 	Rl.Take()
+	if !checkReadToken(c) {
+		return
+	}
 	daysSince, stampedPosts := getChain(c)
-	if daysSince != -1 {
+	if daysSince == -1 {
+		return
+	}
+	if stampedPosts, ok := filterChain(c, stampedPosts); ok {
 		c.JSON(200, gin.H{
 			"marker":     1,
 			"days_since": daysSince,
 			"chain":      stampedPosts,
+			"stats":      u.GetChainStats(stampedPosts),
+		})
+	}
+}
+
+/* Gets the chain as a tree of branches, where each post holds the posts that
+continue from it */
+func GetTree(c *gin.Context) {
+	Rl.Take()
+	if !checkReadToken(c) {
+		return
+	}
+	daysSince, stampedPosts := getChain(c)
+	if daysSince == -1 {
+		return
+	}
+	if stampedPosts, ok := filterChain(c, stampedPosts); ok {
+		c.JSON(200, gin.H{
+			"marker": 1,
+			"tree":   u.BuildTree(stampedPosts),
 		})
 	}
 }
@@ -34,6 +66,9 @@ func GetRawChain(c *gin.Context) {
 validation */
 func AddPost(c *gin.Context) {
 	Rl.Take()
+	if isLockedOut(c) {
+		return
+	}
 	var (
 		post   tp.Post
 		marker int
@@ -47,6 +82,35 @@ func AddPost(c *gin.Context) {
 		return
 	}
 
+	// The authorising passcode and its role are only ever recorded by the
+	// server, as its holder can erase the post
+	post.PasscodeId, post.Role = 0, ""
+	if post.Reveal != nil && !u.IsSealed(post.Reveal) {
+		sendFailure(c, "reveal time must be in the future")
+		return
+	}
+	isShared := post.Shares != 0 || post.Threshold != 0
+	if isShared && x.ValidateShareParams(post.Shares, post.Threshold) != nil {
+		sendFailure(c, x.ErrShareParams.Error())
+		return
+	}
+	isBranching := post.Branches != 0
+	if isBranching && (isShared || post.Branches < 2 ||
+		post.Branches > x.MAX_BRANCHES) {
+		sendFailure(c, x.ErrBranchCount.Error())
+		return
+	}
+	isRevival := post.Revival != ""
+	if isRevival && (isShared || isBranching) {
+		sendFailure(c, "a revival post cannot share or branch its passcode")
+		return
+	}
+
+	// A session token can be provided in place of the hash
+	if !resolveSession(c, &post.Hash) {
+		return
+	}
+
 	// Determining if this is the genesis post
 	isGenesis, err := checkForGenesis()
 	if err != nil {
@@ -54,9 +118,30 @@ func AddPost(c *gin.Context) {
 		return
 	}
 
+	// The post continues the branch of its passcode, from the post that
+	// issued the latest passcode of that branch. A revival names the branch
+	// that it revives, as it has no passcode
+	if isRevival && isGenesis {
+		sendFailure(c, "the genesis post cannot be a revival")
+		return
+	} else if !isRevival {
+		if post.Branch, err = x.PasscodeBranch(dbo, post.Hash); err != nil {
+			sendFailure(c, "error determining branch of passcode")
+			return
+		}
+	}
+	post.ParentId, err = dbo.SelectBranchHeadId(post.Branch)
+	if err != nil {
+		sendFailure(c, "error determining parent of post")
+		return
+	} else if isRevival && post.ParentId == 0 {
+		sendFailure(c, "no branch to revive")
+		return
+	}
+
 	// Need to perform time validation if not genesis post
 	if IsTimeGuarded && !isGenesis {
-		latestTimestamp, err := dbo.SelectLatestTimestamp()
+		latestTimestamp, err := dbo.SelectLatestTimestamp(post.Branch)
 		if err != nil {
 			sendFailure(c, "error determining latest timestamp")
 			return
@@ -66,34 +151,141 @@ func AddPost(c *gin.Context) {
 		}
 	}
 
-	// Need to perform hash validation if not genesis post
+	// Genesis post needs a genesis token, otherwise perform hash validation
 	if isGenesis {
 		marker = 2
 		post.Tag = 0
+		post.HashIndex = -1
+		if err = x.ValidateGenesisHash(dbo, post.Hash); err != nil {
+			recordFailure(c, err)
+			sendFailure(c, "genesis token validation failed")
+			return
+		}
+	} else if isRevival {
+		// Anyone can revive a dormant branch with a proof of work or token
+		marker = 1
+		post.HashIndex = -1
+		err = x.ValidateRevival(
+			dbo, post.Branch, post.Revival, post.Nonce, post.Hash)
+		if err != nil {
+			recordFailure(c, err)
+			sendFailure(c, err.Error())
+			return
+		} else if post.Tag == 0 {
+			sendFailure(c, "unable to perform revival validation")
+			return
+		}
 	} else {
 		marker = 1
-		isValidated, err := x.ValidateHash(dbo, post.Hash)
+		post.HashIndex, post.Role, err = x.ValidateHashIndex(dbo, post.Hash)
+		if err != nil {
+			recordFailure(c, err)
+		}
 		if err != nil || post.Tag == 0 {
 			sendFailure(c, "unable to perform passcode validation")
 			return
-		} else if !isValidated {
+		} else if post.HashIndex == -1 {
 			sendFailure(c, "passcode validation failed")
 			return
 		}
+
+		// Recording the authorising passcode, so its holder can erase the post
+		if post.PasscodeId, err = dbo.SelectPasscodeId(post.Hash); err != nil {
+			sendFailure(c, "error selecting authorising passcode")
+			return
+		}
 	}
 
-	// Generating post descriptors then performing db insert of post
-	post.Descriptors, err = w.GenerateDescriptors()
+	// Generating post descriptors from the word list of its chain, scored
+	// against the post unless in random mode, then performing db insert of
+	// post, with the contents encrypted if the chain is private. Descriptors
+	// are stored in plaintext, so they are never scored on a private chain
+	// where they would give away what the post is about
+	wordList, err := chainWordList(post.Branch, post.ParentId)
+	if err != nil {
+		sendFailure(c, "unable to determine word list of chain")
+		return
+	}
+	memory, _ := strconv.Atoi(os.Getenv("WORD_MEMORY"))
+	recent, err := dbo.SelectRecentDescriptors(memory)
+	if err != nil {
+		sendFailure(c, "error selecting recent descriptors")
+		return
+	}
+	post.WordList = wordList.Name()
+	if os.Getenv("DESCRIPTOR_MODE") == w.MODE_RANDOM || x.IsPrivateChain() {
+		post.Descriptors, err = w.GenerateDescriptors(wordList, recent)
+	} else {
+		post.Descriptors, err = w.SuggestDescriptors(
+			wordList, recent, post.Title+"\n"+post.Contents)
+	}
 	if err != nil {
 		sendFailure(c, "unable to generate descriptors for post")
 		return
-	} else if err = dbo.InsertPost(post); err != nil {
+	}
+	if post.Contents, err = x.EncryptContents(post.Contents); err != nil {
+		sendFailure(c, "unable to encrypt post contents")
+		return
+	}
+	var postId int
+	if isGenesis {
+		postId, err = x.InsertGenesisPost(dbo, post)
+	} else if isRevival {
+		postId, err = x.InsertRevivalPost(dbo, post)
+	} else {
+		postId, err = dbo.InsertPost(post)
+	}
+	if errors.Is(err, x.ErrGenesisToken) {
+		recordFailure(c, err)
+		sendFailure(c, "genesis token validation failed")
+		return
+	} else if errors.Is(err, x.ErrRevival) {
+		recordFailure(c, err)
+		sendFailure(c, err.Error())
+		return
+	} else if err != nil {
 		sendFailure(c, "database operation failed")
 		return
 	}
 
-	// Inserting new passcode and getting cipher
-	cipher, err := x.SetHashAndRetrieveCipher(dbo, isGenesis, post.Hash)
+	// Inserting new passcode issued by the post and getting cipher, or a
+	// cipher per share or branch
+	if isBranching {
+		ciphers, err := x.SetHashesAndRetrieveBranches(
+			dbo, post.Hash, post.Note, postId, post.Branches)
+		if err != nil {
+			sendFailure(c, "error when setting new passcodes for branches")
+			return
+		}
+		c.JSON(201, gin.H{
+			"message":  "post successful",
+			"branches": ciphers,
+			"marker":   marker,
+		})
+		return
+	}
+	if isShared {
+		ciphers, err := x.SetHashAndRetrieveShares(dbo,
+			post.Hash, post.Note, postId, post.Shares, post.Threshold)
+		if err != nil {
+			sendFailure(c, "error when setting new passcode and/or shares")
+			return
+		}
+		c.JSON(201, gin.H{
+			"message": "post successful",
+			"shares":  ciphers,
+			"marker":  marker,
+		})
+		return
+	}
+	var cipher string
+	if isRevival {
+		cipher, err = x.SetRevivalHashAndRetrieveCipher(
+			dbo, post.Hash, post.Note, post.Branch, postId)
+	} else {
+		cipher, err = x.SetHashAndRetrieveCipher(
+			dbo, post.Hash, post.Note, postId)
+	}
 	if err != nil {
 		sendFailure(c, "error when setting new passcode and/or getting cipher")
 		return
@@ -112,6 +304,10 @@ validation Input reaction should be of the format:
 {postId, descriptor, gravitasHash}  */
 func AddReaction(c *gin.Context) {
 	Rl.Take()
+	if isLockedOut(c) {
+		return
+	}
+
 	// Parsing request body
 	var reaction tp.Reaction
 	body, err := c.GetRawData()
@@ -119,25 +315,38 @@ func AddReaction(c *gin.Context) {
 	if err != nil || err2 != nil {
 		sendFailure(c, "invalid request body")
 		return
+	} else if !resolveSession(c, &reaction.GravitasHash) {
+		return
+	} else if isPostSealed(c, reaction.PostId) {
+		return
 	}
 
 	// Checking that we have a correct descriptor and gravitas hash
-	descriptors, err := dbo.SelectDescriptors(reaction.PostId)
+	descriptors, err := dbo.SelectPostDescriptors(reaction.PostId)
 	if err != nil {
 		sendFailure(c, "db error when selecting descriptors")
 		return
-	} else if !u.CheckDescriptor(reaction.Descriptor, descriptors) {
+	}
+	descriptor, ok := u.FindDescriptor(reaction.Descriptor, descriptors)
+	if !ok {
 		sendFailure(c, "invalid reaction descriptor provided")
 		return
 	}
+	reaction.DescriptorId = descriptor.Id
 
 	// Determining the gravitas of reaction and its validity, handling errors.
 	// Also setting the correct gravitas value
 	isValidHash, gravitas, err := x.ValidateReactionHash(
 		dbo, reaction.GravitasHash, reaction.PostId)
 	if err != nil {
+		recordFailure(c, err)
 		sendFailure(c, err.Error())
 		return
+	} else if !isValidHash && len(reaction.GravitasHash) >= 64 {
+		// A full length hash that is not a candidate is a failed guess
+		recordFailure(c, x.ErrUnknownHash)
+		sendFailure(c, "passcode validation failed")
+		return
 	}
 	reaction.Gravitas = gravitas
 
@@ -155,6 +364,19 @@ func AddReaction(c *gin.Context) {
 
 	}
 
+	// Looking up the word list of the post and picking the next descriptor
+	// before anything is stored, so a failure never leaves a reaction behind
+	wordList, err := dbo.SelectPostWordList(reaction.PostId)
+	if err != nil {
+		sendFailure(c, "error selecting word list of post")
+		return
+	}
+	next, err := w.GenerateDescriptor(lookupWordList(wordList))
+	if err != nil {
+		sendFailure(c, "error generating descriptor")
+		return
+	}
+
 	// Finally adding reaction with the correct gravitas
 	if err := dbo.InsertReaction(reaction); err != nil {
 		sendFailure(c, "error when performing db insert")
@@ -164,11 +386,433 @@ func AddReaction(c *gin.Context) {
 	// Sending success response
 	c.JSON(201, gin.H{
 		"message": "reaction successful",
-		"data":    w.GenerateDescriptor(1349),
+		"data":    next,
+		"marker":  1,
+	})
+}
+
+/* Exchanges a passcode hash for a short-lived session token, which can then be
+sent as a bearer token to AddPost and AddReaction in place of the hash. Input
+should be of the format: {hash} */
+func AddSession(c *gin.Context) {
+	Rl.Take()
+	if isLockedOut(c) {
+		return
+	}
+
+	// Parsing request body
+	var proof struct {
+		Hash string `json:"hash"`
+	}
+	body, err := c.GetRawData()
+	err2 := json.Unmarshal(body, &proof)
+	if err != nil || err2 != nil {
+		sendFailure(c, "invalid request body")
+		return
+	}
+
+	token, expiry, err := x.IssueSessionToken(dbo, proof.Hash)
+	if errors.Is(err, x.ErrUnknownHash) {
+		recordFailure(c, err)
+		sendFailure(c, "passcode validation failed")
+		return
+	} else if err != nil {
+		sendFailure(c, "unable to issue session token")
+		return
+	}
+
+	// Sending success response
+	c.JSON(201, gin.H{
+		"message": "session started",
+		"data":    token,
+		"expires": expiry,
 		"marker":  1,
 	})
 }
 
+/* Replaces the current holder's passcode with a fresh one, for when they
+suspect it has leaked. The chain does not advance. Input should be of the
+format: {hash}, or the hash can be provided by a session token */
+func RotatePasscode(c *gin.Context) {
+	Rl.Take()
+	if isLockedOut(c) {
+		return
+	}
+
+	// Parsing request body
+	var proof struct {
+		Hash string `json:"hash"`
+	}
+	body, err := c.GetRawData()
+	err2 := json.Unmarshal(body, &proof)
+	if err != nil || err2 != nil {
+		sendFailure(c, "invalid request body")
+		return
+	} else if !resolveSession(c, &proof.Hash) {
+		return
+	}
+
+	cipher, err := x.RotateHeadHash(dbo, proof.Hash, clientHash(c))
+	if err != nil {
+		recordFailure(c, err)
+		sendFailure(c, err.Error())
+		return
+	}
+
+	// Sending success response
+	c.JSON(201, gin.H{
+		"message": "passcode rotated",
+		"data":    cipher,
+		"marker":  1,
+	})
+}
+
+/* Lets the current holder forfeit their exclusive window, so that previous
+holders can post straight away. The forfeit is shown on the chain. Input
+should be of the format: {hash}, or the hash can be provided by a session
+token */
+func ForfeitPasscode(c *gin.Context) {
+	Rl.Take()
+	if isLockedOut(c) {
+		return
+	}
+
+	// Parsing request body
+	var proof struct {
+		Hash string `json:"hash"`
+	}
+	body, err := c.GetRawData()
+	err2 := json.Unmarshal(body, &proof)
+	if err != nil || err2 != nil {
+		sendFailure(c, "invalid request body")
+		return
+	} else if !resolveSession(c, &proof.Hash) {
+		return
+	}
+
+	if err = x.ForfeitHead(dbo, proof.Hash); err != nil {
+		recordFailure(c, err)
+		sendFailure(c, err.Error())
+		return
+	}
+
+	// Sending success response
+	c.JSON(201, gin.H{
+		"message": "passcode forfeited",
+		"marker":  1,
+	})
+}
+
+/* Gets what a passcode can do without posting or reacting: its role in the
+candidate window, whether and when it can post, its gravitas on each post and
+the posts it has reacted to. Input should be of the format: {hash}, where the
+hash may instead come from a session token */
+func GetPasscodeStatus(c *gin.Context) {
+	Rl.Take()
+	if !StatusRl.Allow(clientHash(c), time.Now()) {
+		c.JSON(429, gin.H{
+			"message": "too many status requests, try again in a minute",
+			"marker":  0,
+		})
+		return
+	} else if isLockedOut(c) {
+		return
+	}
+
+	// Parsing request body
+	var proof struct {
+		Hash string `json:"hash"`
+	}
+	body, err := c.GetRawData()
+	err2 := json.Unmarshal(body, &proof)
+	if err != nil || err2 != nil {
+		sendFailure(c, "invalid request body")
+		return
+	} else if !resolveSession(c, &proof.Hash) {
+		return
+	}
+
+	status, err := x.PasscodeStatus(dbo, proof.Hash)
+	if errors.Is(err, x.ErrUnknownHash) {
+		recordFailure(c, err)
+		sendFailure(c, "passcode validation failed")
+		return
+	} else if err != nil {
+		sendFailure(c, "error determining passcode status")
+		return
+	}
+	c.JSON(200, gin.H{
+		"status": status,
+		"marker": 1,
+	})
+}
+
+/* Rebuilds a passcode held by a group from at least the threshold number of
+its shares, each decrypted from its cipher. Input should be of the format:
+{shares: [share, share...]} */
+func CombineShares(c *gin.Context) {
+	Rl.Take()
+	if isLockedOut(c) {
+		return
+	}
+
+	// Parsing request body
+	var combination struct {
+		Shares []string `json:"shares"`
+	}
+	body, err := c.GetRawData()
+	err2 := json.Unmarshal(body, &combination)
+	if err != nil || err2 != nil || len(combination.Shares) > x.MAX_SHARES {
+		sendFailure(c, "invalid request body")
+		return
+	}
+
+	passcode, err := x.CombinePasscodeShares(dbo, combination.Shares)
+	if err != nil {
+		recordFailure(c, err)
+		sendFailure(c, err.Error())
+		return
+	}
+
+	// Sending success response
+	c.JSON(200, gin.H{
+		"message": "passcode rebuilt",
+		"data":    passcode,
+		"marker":  1,
+	})
+}
+
+/* Returns the private note left by the previous holder to whoever proves the
+passcode it was left for. The raw passcode is needed since the note is sealed
+under it. Input should be of the format: {passcode} */
+func GetHolderNote(c *gin.Context) {
+	Rl.Take()
+	if isLockedOut(c) {
+		return
+	}
+
+	// Parsing request body
+	var proof struct {
+		Passcode string `json:"passcode"`
+	}
+	body, err := c.GetRawData()
+	err2 := json.Unmarshal(body, &proof)
+	if err != nil || err2 != nil {
+		sendFailure(c, "invalid request body")
+		return
+	}
+
+	note, err := x.ReadHolderNote(dbo, proof.Passcode)
+	if errors.Is(err, x.ErrUnknownHash) {
+		recordFailure(c, err)
+		sendFailure(c, "passcode validation failed")
+		return
+	} else if err != nil {
+		sendFailure(c, err.Error())
+		return
+	}
+
+	// Sending success response
+	c.JSON(200, gin.H{
+		"message": "note retrieved",
+		"data":    note,
+		"marker":  1,
+	})
+}
+
+/* Erases a post for its author, replacing it with a tombstone that keeps its
+place in the chain. Input should be of the format: {postId, hash}, where the
+hash is of the passcode that authorised the post or comes from a session
+token */
+func ErasePost(c *gin.Context) {
+	Rl.Take()
+	if isLockedOut(c) {
+		return
+	}
+
+	// Parsing request body
+	var proof struct {
+		PostId int    `json:"postId"`
+		Hash   string `json:"hash"`
+	}
+	body, err := c.GetRawData()
+	err2 := json.Unmarshal(body, &proof)
+	if err != nil || err2 != nil {
+		sendFailure(c, "invalid request body")
+		return
+	} else if !resolveSession(c, &proof.Hash) {
+		return
+	}
+
+	if err = x.ErasePost(dbo, proof.PostId, proof.Hash); err != nil {
+		recordFailure(c, err)
+		sendFailure(c, err.Error())
+		return
+	}
+
+	// Sending success response
+	c.JSON(200, gin.H{
+		"message": "post erased",
+		"marker":  1,
+	})
+}
+
+/* Gets Chain Law for the branch given in the branch query parameter, or
+otherwise the original chain. Gives the time of the head post, and when each
+tier of candidates can post */
+func GetChainLaw(c *gin.Context) {
+	Rl.Take()
+	if !checkReadToken(c) {
+		return
+	}
+	_, headTime, isForfeited, ok := getHead(c)
+	if !ok {
+		return
+	}
+	c.JSON(200, gin.H{
+		"head":      headTime,
+		"forfeited": isForfeited,
+		"tiers":     u.ChainLaw(headTime, isForfeited),
+		"marker":    1,
+	})
+}
+
+/* Gets the proof of work challenge for reviving a dormant branch, given in the
+branch query parameter or otherwise the original chain */
+func GetRevivalChallenge(c *gin.Context) {
+	Rl.Take()
+	if isLockedOut(c) {
+		return
+	}
+	branch, err := strconv.Atoi(c.DefaultQuery("branch", "0"))
+	if err != nil {
+		sendFailure(c, "error parsing branch query parameter")
+		return
+	}
+
+	challenge, difficulty, err := x.RevivalChallenge(dbo, branch)
+	if err != nil {
+		sendFailure(c, err.Error())
+		return
+	}
+	c.JSON(200, gin.H{
+		"challenge":  challenge,
+		"difficulty": difficulty,
+		"marker":     1,
+	})
+}
+
+/* Gets the graph of forks, where each fork names the post and chain that it
+was forked from */
+func GetForks(c *gin.Context) {
+	Rl.Take()
+	if !checkReadToken(c) {
+		return
+	}
+	daysSince, stampedPosts := getChain(c)
+	if daysSince == -1 {
+		return
+	}
+	forks, err := dbo.SelectForks()
+	if err != nil {
+		sendFailure(c, "selecting forks database operation failed")
+		return
+	}
+	_, forks = u.AssignChains(stampedPosts, forks)
+	c.JSON(200, gin.H{
+		"marker": 1,
+		"forks":  forks,
+	})
+}
+
+/* Forks a new chain from a post for the current holder of its branch. Input
+should be of the format: {postId, hash}. Responds with the genesis passcode of
+the fork encrypted with the holder's hash */
+func ForkPost(c *gin.Context) {
+	Rl.Take()
+	if isLockedOut(c) {
+		return
+	}
+
+	// Parsing request body
+	var proof struct {
+		PostId   int    `json:"postId"`
+		Hash     string `json:"hash"`
+		WordList string `json:"wordList"`
+	}
+	body, err := c.GetRawData()
+	err2 := json.Unmarshal(body, &proof)
+	if err != nil || err2 != nil {
+		sendFailure(c, "invalid request body")
+		return
+	} else if !resolveSession(c, &proof.Hash) {
+		return
+	}
+
+	fork, cipher, err := x.HolderForkChain(
+		dbo, proof.PostId, proof.Hash, proof.WordList)
+	if err != nil {
+		recordFailure(c, err)
+		sendFailure(c, err.Error())
+		return
+	}
+
+	// Sending success response
+	c.JSON(201, gin.H{
+		"message": "chain forked",
+		"data":    cipher,
+		"fork":    fork,
+		"marker":  1,
+	})
+}
+
+/* Stores a chain read token in a cookie, so that a browser can read a private
+chain without sending a header. Input should be of the format: {token} */
+func SetReadCookie(c *gin.Context) {
+	Rl.Take()
+	if isLockedOut(c) {
+		return
+	}
+
+	// Parsing request body
+	var proof struct {
+		Token string `json:"token"`
+	}
+	body, err := c.GetRawData()
+	err2 := json.Unmarshal(body, &proof)
+	if err != nil || err2 != nil || proof.Token == "" {
+		sendFailure(c, "invalid request body")
+		return
+	}
+
+	c.Request.Header.Set("X-Read-Token", proof.Token)
+	if !checkReadToken(c) {
+		return
+	}
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(READ_COOKIE, proof.Token, 0, "/", "", isHttps(c), true)
+
+	// Sending success response
+	c.JSON(200, gin.H{
+		"message": "read token stored",
+		"marker":  1,
+	})
+}
+
+/* Determines whether a post is still sealed, in which case it cannot be
+reacted to. Sends a failure response if so */
+func isPostSealed(c *gin.Context, postId int) bool {
+	reveal, err := dbo.SelectPostReveal(postId)
+	if err != nil {
+		sendFailure(c, "db error when selecting post reveal time")
+		return true
+	} else if u.IsSealed(&reveal) {
+		sendFailure(c, "post is sealed until "+u.GetTimestring(reveal))
+		return true
+	}
+	return false
+}
+
 /* Determining if this is the genesis post */
 func checkForGenesis() (bool, error) {
 	// Selecting the existing chain
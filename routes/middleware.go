@@ -0,0 +1,79 @@
+package routes
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+/* Gets the single middleware applying the configured security policy to every
+route. Covers CORS, the content security policy for the client under /w,
+HSTS and other security headers. Reads config when called, so should be
+called after config is set up */
+func SecurityPolicy() gin.HandlerFunc {
+	applyCors := cors.New(corsConfig())
+	csp := os.Getenv("CSP")
+	hstsMaxAge := os.Getenv("HSTS_MAX_AGE")
+	referrerPolicy := os.Getenv("REFERRER_POLICY")
+
+	return func(c *gin.Context) {
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Header("X-Frame-Options", "DENY")
+		if csp != "" {
+			c.Header("Content-Security-Policy", csp)
+		}
+		if referrerPolicy != "" {
+			c.Header("Referrer-Policy", referrerPolicy)
+		}
+
+		// HSTS is only honoured by browsers over https
+		if hstsMaxAge != "" && hstsMaxAge != "0" && isHttps(c) {
+			c.Header("Strict-Transport-Security",
+				"max-age="+hstsMaxAge+"; includeSubDomains")
+		}
+
+		// Same origin requests pass through, disallowed origins are refused
+		applyCors(c)
+	}
+}
+
+/* Builds the CORS config from the allowed origins and methods. With no
+allowed origins, only same origin requests are permitted */
+func corsConfig() cors.Config {
+	origins := splitList(os.Getenv("ALLOWED_ORIGINS"))
+	corsConf := cors.Config{
+		AllowMethods: splitList(os.Getenv("ALLOWED_METHODS")),
+		AllowHeaders: []string{"Origin", "Content-Length", "Content-Type",
+			"Authorization", "X-Read-Token"},
+		MaxAge: 12 * time.Hour,
+	}
+
+	if len(origins) == 0 {
+		corsConf.AllowOriginFunc = func(origin string) bool { return false }
+	} else if len(origins) == 1 && origins[0] == "*" {
+		corsConf.AllowAllOrigins = true
+	} else {
+		corsConf.AllowOrigins = origins
+	}
+	return corsConf
+}
+
+/* Determines whether a request reached us, or the proxy in front, over https */
+func isHttps(c *gin.Context) bool {
+	return c.Request.TLS != nil ||
+		c.GetHeader("X-Forwarded-Proto") == "https"
+}
+
+/* Splits a comma separated config value, dropping empty entries */
+func splitList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
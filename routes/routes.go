@@ -1,22 +1,33 @@
 package routes
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	d "github.com/georgejmx/whisper-blog/controller"
+	x "github.com/georgejmx/whisper-blog/security"
 	tp "github.com/georgejmx/whisper-blog/types"
 	u "github.com/georgejmx/whisper-blog/utils"
+	w "github.com/georgejmx/whisper-blog/words"
 	"go.uber.org/ratelimit"
 
 	"github.com/gin-gonic/gin"
 )
 
 var (
-	Rl  ratelimit.Limiter
-	dbo tp.ControllerTemplate
+	Rl       ratelimit.Limiter
+	StatusRl *x.ClientLimiter // limits each client's passcode status requests
+	dbo      tp.ControllerTemplate
 )
 
+// Cookie that a browser keeps its chain read token in
+const READ_COOKIE = "read_token"
+
 /* Establishes database connection and controller object, else panics */
 func SetupDatabase() {
 	dbo = &d.DbController{}
@@ -25,11 +36,24 @@ func SetupDatabase() {
 	}
 }
 
+/* Periodically retires expired passcodes in the background, for as long as the
+program runs */
+func SweepPasscodes(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			retired, err := x.RetireExpiredPasscodes(dbo)
+			if err != nil {
+				log.Printf("unable to retire expired passcodes: %v", err)
+			} else if retired > 0 {
+				log.Printf("retired %d expired passcodes", retired)
+			}
+		}
+	}()
+}
+
 /* Gets chain from backend, returning it as a type. This means output can be
 parsed both as JSON and HTML */
 func getChain(c *gin.Context) (int, []tp.Post) {
-	attachHeaders(c)
-
 	// Selecting posts data
 	posts, err := dbo.SelectPosts()
 	if err != nil {
@@ -37,9 +61,37 @@ func getChain(c *gin.Context) (int, []tp.Post) {
 		return -1, []tp.Post{}
 	}
 
-	// Attaching top reactions to each post, in a modified slice
+	forfeits, err := dbo.SelectForfeits()
+	if err != nil {
+		sendFailure(c, "selecting forfeits database operation failed")
+		return -1, []tp.Post{}
+	}
+	forks, err := dbo.SelectForks()
+	if err != nil {
+		sendFailure(c, "selecting forks database operation failed")
+		return -1, []tp.Post{}
+	}
+
+	// Attaching top reactions and forfeits to each post, in a modified slice
 	var stampedPosts []tp.Post
 	for _, val := range posts {
+		if forfeited, ok := forfeits[val.Id]; ok {
+			val.Forfeited = &forfeited
+		}
+		if val.Contents, err = x.DecryptContents(val.Contents); err != nil {
+			sendFailure(c, fmt.Sprintf("error decrypting post %v", val.Id))
+			return -1, []tp.Post{}
+		}
+		val.Provenance = u.GetProvenance(val.HashIndex, val.Role)
+		if val.Revival != "" {
+			val.Provenance = u.REVIVED_PROVENANCE
+		}
+
+		// Sealed posts are a link in the chain with nothing else shown
+		if u.IsSealed(val.Reveal) {
+			stampedPosts = append(stampedPosts, u.SealPost(val))
+			continue
+		}
 		postReactions, err := dbo.SelectPostReactions(val.Id)
 		if err != nil {
 			sendFailure(c, fmt.Sprintf("error getting reactions of %v", val.Id))
@@ -49,6 +101,8 @@ func getChain(c *gin.Context) (int, []tp.Post) {
 		stampedPosts = append(stampedPosts, val)
 	}
 
+	stampedPosts, _ = u.AssignChains(stampedPosts, forks)
+
 	// Calculating days since previous post
 	var daysSince int
 	if len(stampedPosts) == 0 {
@@ -62,20 +116,159 @@ func getChain(c *gin.Context) (int, []tp.Post) {
 	return daysSince, stampedPosts
 }
 
-/* Allowing test database to be cleared by integration tests */
-func Clear() bool { return dbo.Clear() }
+/* Narrows the posts down to the chain in the chain query parameter, or leaves
+them all if there is none. Sends a failure response if it is not a number */
+func filterChain(c *gin.Context, posts []tp.Post) ([]tp.Post, bool) {
+	param := c.Query("chain")
+	if param == "" {
+		return posts, true
+	}
+	chain, err := strconv.Atoi(param)
+	if err != nil {
+		sendFailure(c, "error parsing chain query parameter")
+		return posts, false
+	}
+	return u.FilterChain(posts, chain), true
+}
+
+/* Gets the head post time of the branch in the branch query parameter, or
+otherwise the original chain, and whether its holder has forfeited. Sends a
+failure response if any cannot be found */
+func getHead(c *gin.Context) (int, time.Time, bool, bool) {
+	branch, err := strconv.Atoi(c.DefaultQuery("branch", "0"))
+	if err != nil {
+		sendFailure(c, "error parsing branch query parameter")
+		return 0, time.Time{}, false, false
+	}
+	headTime, err := dbo.SelectLatestTimestamp(branch)
+	if err != nil {
+		sendFailure(c, "selecting head time database operation failed")
+		return 0, time.Time{}, false, false
+	}
+	isForfeited, err := dbo.SelectHeadForfeited(branch)
+	if err != nil {
+		sendFailure(c, "selecting forfeit database operation failed")
+		return 0, time.Time{}, false, false
+	}
+	return branch, headTime, isForfeited, true
+}
+
+/* Gets the word list of the chain that a post on the branch continues from
+its parent. A fork starts its chain with the list it chose, otherwise a post
+keeps the list of its parent and the original chain uses the configured list */
+func chainWordList(branch, parentId int) (w.WordList, error) {
+	name, err := dbo.SelectForkWordList(branch)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && name == "") {
+		name, err = "", nil
+		if parentId != 0 {
+			name, err = dbo.SelectPostWordList(parentId)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return lookupWordList(name), nil
+}
+
+/* Finds the word list of the provided name. A list that is no longer loaded,
+such as one removed from disk, falls back to the configured list */
+func lookupWordList(name string) w.WordList {
+	list, err := w.Lookup(name)
+	if err != nil {
+		log.Printf("word list %q unavailable, using default: %v", name, err)
+		list, err = w.Lookup("")
+	}
+	if err != nil {
+		list, _ = w.Lookup(w.DEFAULT_LIST)
+	}
+	return list
+}
+
+/* Hashes the identity of the requesting client, so that raw addresses are never
+stored alongside security events */
+func clientHash(c *gin.Context) string {
+	return x.RawToHash(c.ClientIP())
+}
+
+/* Records a failed validation by the requesting client as a security event */
+func recordFailure(c *gin.Context, err error) {
+	if err := x.RecordFailure(dbo, clientHash(c), err); err != nil {
+		log.Printf("unable to record security event: %v", err)
+	}
+}
+
+/* Determines whether the requesting client is locked out after repeated
+failures, sending a failure response if so */
+func isLockedOut(c *gin.Context) bool {
+	remaining, err := x.CheckLockout(dbo, clientHash(c))
+	if err != nil {
+		sendFailure(c, "error determining client lockout")
+		return true
+	} else if remaining > 0 {
+		sendFailure(c, fmt.Sprintf("too many failed attempts, try again in %v",
+			remaining.Round(time.Second)))
+		return true
+	}
+	return false
+}
 
-/* Attaches CORS headers to the current context */
-func attachHeaders(c *gin.Context) *gin.Context {
-	c.Header("Access-Control-Allow-Origin", "*")
-	c.Header("Access-Control-Allow-Credentials", "true")
-	c.Header("Access-Control-Allow-Headers",
-		`Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, 
-		Authorization, accept, origin, Cache-Control, X-Requested-With`)
-	c.Header("Access-Control-Allow-Methods", "GET,POST,HEAD,OPTIONS")
-	return c
+/* Checks that a request to read a private chain carries an active read token,
+from either the *X-Read-Token* header or the *read_token* cookie. Sends a
+failure response if not. Public chains can be read by anyone */
+func checkReadToken(c *gin.Context) bool {
+	if !x.IsPrivateChain() {
+		return true
+	} else if isLockedOut(c) {
+		return false
+	}
+
+	token := c.GetHeader("X-Read-Token")
+	if token == "" {
+		token, _ = c.Cookie(READ_COOKIE)
+	}
+	err := x.ValidateReadToken(dbo, token)
+	if err == nil {
+		return true
+	} else if !errors.Is(err, x.ErrReadToken) {
+		sendFailure(c, "error validating read token")
+		return false
+	}
+
+	// Only a token that was provided counts as a failed attempt
+	if token != "" {
+		recordFailure(c, err)
+	}
+	c.JSON(401, gin.H{
+		"message": err.Error(),
+		"marker":  0,
+	})
+	return false
+}
+
+/* Replaces the provided hash with the one held by the bearer session token,
+if the request carries one. Sends a failure response if the token is unusable */
+func resolveSession(c *gin.Context, hash *string) bool {
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !found {
+		return true
+	}
+
+	resolved, err := x.ResolveSessionToken(dbo, token)
+	if err != nil {
+		recordFailure(c, err)
+		sendFailure(c, err.Error())
+		return false
+	}
+	*hash = resolved
+	return true
 }
 
+/* Issues a one-time genesis token, for the admin CLI and integration tests */
+func IssueGenesisToken() (string, error) { return x.IssueGenesisToken(dbo) }
+
+/* Allowing test database to be cleared by integration tests */
+func Clear() bool { return dbo.Clear() }
+
 /* Sends a HTTP failure response */
 func sendFailure(context *gin.Context, msg string) {
 	context.JSON(400, gin.H{
@@ -0,0 +1,72 @@
+package security
+
+import (
+	"database/sql"
+	"errors"
+
+	tp "github.com/georgejmx/whisper-blog/types"
+	u "github.com/georgejmx/whisper-blog/utils"
+)
+
+// Most passcodes that a single post can branch into
+const MAX_BRANCHES = 4
+
+// Returned when a post asks for an unusable number of branches
+var ErrBranchCount = errors.New("a post can branch into 2 to 4 passcodes")
+
+/* Gets the branch of the passcode with the provided hash. Hashes that are not
+passcodes, such as the genesis token, belong to the first branch */
+func PasscodeBranch(dbo tp.ControllerTemplate, hash string) (int, error) {
+	branch, err := dbo.SelectPasscodeBranch(hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return branch, err
+}
+
+/* Sets a randomly generated hash for each of *n* branches continuing from the
+post with id *postId*. The first continues the branch of the previous hash and
+the rest each start a new one. Returns each passcode in its own cipher,
+encrypted the same way as a single passcode */
+func SetHashesAndRetrieveBranches(dbo tp.ControllerTemplate,
+	prevHash, note string, postId, n int) ([]string, error) {
+	if n < 2 || n > MAX_BRANCHES {
+		return nil, ErrBranchCount
+	}
+	branch, err := PasscodeBranch(dbo, prevHash)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphers := make([]string, n)
+	for i := range ciphers {
+		if i > 0 {
+			if branch, err = dbo.SelectNextBranch(); err != nil {
+				return nil, err
+			}
+		}
+		rawPasscode := u.GenerateRawPasscode()
+		err = insertNextHash(
+			dbo, prevHash, rawPasscode, note, branch, postId)
+		if err != nil {
+			return nil, err
+		}
+		ciphers[i], err = encryptPasscode(rawPasscode, prevHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ciphers, nil
+}
+
+/* Selects the candidate window of the branch that the provided hash is on,
+along with that branch */
+func branchCandidates(
+	dbo tp.ControllerTemplate, hash string) ([]tp.Candidate, int, error) {
+	branch, err := PasscodeBranch(dbo, hash)
+	if err != nil {
+		return nil, 0, err
+	}
+	window, err := candidateWindow(dbo, branch)
+	return window, branch, err
+}
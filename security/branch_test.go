@@ -0,0 +1,56 @@
+package security
+
+import (
+	"os"
+	"testing"
+
+	mock "github.com/georgejmx/whisper-blog/utils"
+)
+
+/* Checks that a post branches into one passcode per branch, each readable by
+the previous holder, and that unusable branch counts are refused */
+func TestSetHashesAndRetrieveBranches(t *testing.T) {
+	os.Setenv("AES_SPLICE_INDEX", "28")
+	os.Setenv("AES_IV", "snooping6is9bad0")
+	controller := &mock.MockController{}
+
+	ciphers, err := SetHashesAndRetrieveBranches(
+		controller, mock.MockHashes[0], "", mock.MockPost.Id, 3)
+	if err != nil || len(ciphers) != 3 {
+		t.Fatalf("unable to set hashes for branches: %v", err)
+	}
+	passcodes := make(map[string]bool)
+	for _, ciphercode := range ciphers {
+		passcode, err := DecryptCipher(mock.MockHashes[0], ciphercode)
+		if err != nil || len(passcode) != 12 {
+			t.Fatalf("incorrect passcode decrypted: %s", passcode)
+		}
+		passcodes[passcode] = true
+	}
+	if len(passcodes) != 3 {
+		t.Log("expected a different passcode for each branch")
+		t.Fail()
+	}
+
+	for _, n := range []int{1, MAX_BRANCHES + 1} {
+		_, err = SetHashesAndRetrieveBranches(
+			controller, mock.MockHashes[0], "", mock.MockPost.Id, n)
+		if err != ErrBranchCount {
+			t.Logf("expected branch count error for %d, found %v", n, err)
+			t.Fail()
+		}
+	}
+}
+
+/* Checks that hashes which are not passcodes belong to the first branch */
+func TestPasscodeBranch(t *testing.T) {
+	controller := &mock.MockController{}
+	for _, hash := range []string{mock.MockHashes[1], mock.MockGenesisToken} {
+		if branch, err := PasscodeBranch(controller, hash); branch != 0 ||
+			err != nil {
+			t.Logf("expected first branch for %s, found %d %v", hash, branch,
+				err)
+			t.Fail()
+		}
+	}
+}
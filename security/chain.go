@@ -0,0 +1,95 @@
+package security
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	tp "github.com/georgejmx/whisper-blog/types"
+	u "github.com/georgejmx/whisper-blog/utils"
+)
+
+// Errors returned when reading a private chain
+var (
+	ErrReadToken   = errors.New("a valid read token is required for this chain")
+	ErrNoReadToken = errors.New("no active read token with this id")
+	ErrContentsBad = errors.New("post contents could not be decrypted")
+)
+
+// Marks post contents that are stored encrypted under the chain secret
+const ENCRYPTED_PREFIX = "enc:"
+
+/* Determines whether the chain is private, which is when a chain secret is
+configured */
+func IsPrivateChain() bool {
+	return os.Getenv("CHAIN_SECRET") != ""
+}
+
+/* Encrypts post contents for storage when the chain is private, otherwise
+returns them unchanged */
+func EncryptContents(contents string) (string, error) {
+	if !IsPrivateChain() {
+		return contents, nil
+	}
+	sealed, err := sealHex("chain", os.Getenv("CHAIN_SECRET"), contents)
+	if err != nil {
+		return "", err
+	}
+	return ENCRYPTED_PREFIX + sealed, nil
+}
+
+/* Decrypts stored post contents. Contents stored before the chain was made
+private are returned unchanged */
+func DecryptContents(stored string) (string, error) {
+	sealed, isEncrypted := strings.CutPrefix(stored, ENCRYPTED_PREFIX)
+	if !isEncrypted {
+		return stored, nil
+	} else if !IsPrivateChain() {
+		return "", ErrContentsBad
+	}
+	contents, err := openHex("chain", os.Getenv("CHAIN_SECRET"), sealed)
+	if err != nil {
+		return "", ErrContentsBad
+	}
+	return contents, nil
+}
+
+/* Issues a new read token for the chain. Only its hash is stored, so the token
+is only shown here. Returns the token and the id used to revoke it */
+func IssueReadToken(dbo tp.ControllerTemplate) (string, int, error) {
+	rawToken := u.GenerateRawPasscode() + u.GenerateRawPasscode()
+	id, err := dbo.InsertReadToken(RawToHash(rawToken))
+	if err != nil {
+		return "", 0, err
+	}
+	return rawToken, id, nil
+}
+
+/* Validates a read token against those that have not been revoked. Any token
+is accepted for a public chain. Revoking a token does not change the key that
+contents are encrypted with, so history never needs re-encrypting */
+func ValidateReadToken(dbo tp.ControllerTemplate, rawToken string) error {
+	if !IsPrivateChain() {
+		return nil
+	} else if rawToken == "" {
+		return ErrReadToken
+	}
+	isActive, err := dbo.SelectReadTokenActive(RawToHash(rawToken))
+	if err != nil {
+		return err
+	} else if !isActive {
+		return ErrReadToken
+	}
+	return nil
+}
+
+/* Revokes the read token with the provided id */
+func RevokeReadToken(dbo tp.ControllerTemplate, id int) error {
+	isRevoked, err := dbo.RevokeReadToken(id)
+	if err != nil {
+		return err
+	} else if !isRevoked {
+		return ErrNoReadToken
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package security
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	mock "github.com/georgejmx/whisper-blog/utils"
+)
+
+/* Checks that contents are only encrypted for a private chain, and that
+contents stored before the chain was made private can still be read */
+func TestEncryptContents(t *testing.T) {
+	defer os.Setenv("CHAIN_SECRET", "")
+	contents := "our quarterly plans"
+
+	os.Setenv("CHAIN_SECRET", "")
+	if stored, _ := EncryptContents(contents); stored != contents {
+		t.Log("expected contents of a public chain to be stored as is")
+		t.Fail()
+	}
+
+	os.Setenv("CHAIN_SECRET", "test6chain9secret0")
+	stored, err := EncryptContents(contents)
+	if err != nil || !strings.HasPrefix(stored, ENCRYPTED_PREFIX) ||
+		strings.Contains(stored, contents) {
+		t.Fatalf("expected contents to be encrypted, found %q", stored)
+	}
+	if decrypted, err := DecryptContents(stored); decrypted != contents {
+		t.Logf("expected %q, found %q with %v", contents, decrypted, err)
+		t.Fail()
+	}
+	if legacy, _ := DecryptContents(contents); legacy != contents {
+		t.Log("expected unencrypted contents to be returned as is")
+		t.Fail()
+	}
+
+	// Contents cannot be read with a different secret, or none at all
+	for _, secret := range []string{"other6chain9secret0", ""} {
+		os.Setenv("CHAIN_SECRET", secret)
+		if _, err = DecryptContents(stored); err != ErrContentsBad {
+			t.Logf("expected decryption with %q to fail, found %v", secret, err)
+			t.Fail()
+		}
+	}
+}
+
+/* Checks that only active read tokens can read a private chain, while anyone
+can read a public chain */
+func TestValidateReadToken(t *testing.T) {
+	controller := &mock.MockController{}
+	defer os.Setenv("CHAIN_SECRET", "")
+
+	os.Setenv("CHAIN_SECRET", "")
+	if err := ValidateReadToken(controller, ""); err != nil {
+		t.Logf("expected public chain to be readable, found %v", err)
+		t.Fail()
+	}
+
+	os.Setenv("CHAIN_SECRET", "test6chain9secret0")
+	if err := ValidateReadToken(controller, mock.MockReadToken); err != nil {
+		t.Logf("expected active read token to be valid, found %v", err)
+		t.Fail()
+	}
+	for _, token := range []string{"", "READtokenccc8d659a2ff"} {
+		if err := ValidateReadToken(controller, token); err != ErrReadToken {
+			t.Logf("expected %q to be refused, found %v", token, err)
+			t.Fail()
+		}
+	}
+
+	if err := RevokeReadToken(controller, 2); err != ErrNoReadToken {
+		t.Logf("expected no token to revoke, found %v", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,44 @@
+package security
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	tp "github.com/georgejmx/whisper-blog/types"
+	u "github.com/georgejmx/whisper-blog/utils"
+)
+
+// Errors returned when a post cannot be erased
+var (
+	ErrNoPost    = errors.New("no post with this id")
+	ErrNotAuthor = errors.New("passcode did not authorise this post")
+)
+
+/* Erases a post for its author, who proves the passcode that authorised it.
+The title, contents and author are replaced with a tombstone while the post
+keeps its place and time in the chain, so the Chain Law is unchanged. Its
+reactions are dropped if configured */
+func ErasePost(dbo tp.ControllerTemplate, postId int, hash string) error {
+	passcodeId, err := dbo.SelectPasscodeId(hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUnknownHash
+	} else if err != nil {
+		return err
+	}
+
+	// Posts without a recorded passcode, such as the genesis post, are refused
+	authorId, err := dbo.SelectPostPasscodeId(postId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNoPost
+	} else if err != nil {
+		return err
+	} else if authorId == 0 || authorId != passcodeId {
+		return ErrNotAuthor
+	}
+
+	// Titles are unique, so each tombstone is numbered by its post
+	return dbo.ErasePost(postId, fmt.Sprintf("%s #%d", u.ERASED_TITLE, postId),
+		u.ERASED_PLACEHOLDER, os.Getenv("ERASE_REACTIONS") == "drop")
+}
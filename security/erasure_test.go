@@ -0,0 +1,36 @@
+package security
+
+import (
+	"testing"
+
+	mock "github.com/georgejmx/whisper-blog/utils"
+)
+
+/* Checks that only the passcode that authorised a post can erase it */
+func TestErasePost(t *testing.T) {
+	controller := &mock.MockController{}
+
+	// The mock post was authorised by the previous holder
+	if err := ErasePost(controller, 1, mock.MockHashes[1]); err != nil {
+		t.Logf("expected author to erase post, found %v", err)
+		t.Fail()
+	}
+
+	cases := []struct {
+		postId int
+		hash   string
+		err    error
+	}{
+		{1, mock.MockHashes[0], ErrNotAuthor},
+		{1, mock.InvalidMockHashes[0], ErrUnknownHash},
+		{mock.MockSealedPostId, mock.MockHashes[1], ErrNotAuthor},
+		{99, mock.MockHashes[1], ErrNoPost},
+	}
+	for _, c := range cases {
+		if err := ErasePost(controller, c.postId, c.hash); err != c.err {
+			t.Logf("expected %v erasing post %d, found %v", c.err, c.postId,
+				err)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package security
+
+import (
+	"database/sql"
+	"errors"
+
+	tp "github.com/georgejmx/whisper-blog/types"
+	u "github.com/georgejmx/whisper-blog/utils"
+	w "github.com/georgejmx/whisper-blog/words"
+)
+
+// Who authorised a fork
+const (
+	FORK_ADMIN  = "admin"
+	FORK_HOLDER = "holder"
+)
+
+// Returned when a passcode other than the current holder's tries to fork
+var ErrForkNotHolder = errors.New(
+	"only the current holder of the source post's branch can fork it")
+
+/* Forks a new chain from the source post, issuing a fresh genesis passcode
+that continues from it on a branch of its own. The fork can choose the word
+list its descriptors are picked from, otherwise it keeps that of the source
+post. Returns the fork along with the raw genesis passcode, which is only ever
+shown to whoever authorised it */
+func ForkChain(dbo tp.ControllerTemplate, sourcePostId int,
+	authority, wordList string) (tp.Fork, string, error) {
+	if wordList != "" {
+		list, err := w.Lookup(wordList)
+		if err != nil {
+			return tp.Fork{}, "", err
+		} else if err = w.CheckList(list); err != nil {
+			return tp.Fork{}, "", err
+		}
+	}
+	_, err := dbo.SelectPostBranch(sourcePostId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return tp.Fork{}, "", ErrNoPost
+	} else if err != nil {
+		return tp.Fork{}, "", err
+	}
+	branch, err := dbo.SelectNextBranch()
+	if err != nil {
+		return tp.Fork{}, "", err
+	}
+
+	rawPasscode := u.GenerateRawPasscode()
+	fork := tp.Fork{
+		SourcePostId: sourcePostId,
+		Branch:       branch,
+		Authority:    authority,
+		WordList:     wordList,
+	}
+	fork.Id, err = dbo.InsertFork(fork, RawToHash(rawPasscode),
+		expiryFromNow("POST_EXPIRY"), expiryFromNow("REACT_EXPIRY"))
+	if err != nil {
+		return tp.Fork{}, "", err
+	}
+	return fork, rawPasscode, nil
+}
+
+/* Forks a new chain as above for the current holder of the branch that the
+source post is on. The genesis passcode of the fork is returned encrypted with
+their hash, the same as a passcode for the next holder */
+func HolderForkChain(dbo tp.ControllerTemplate, sourcePostId int,
+	hash, wordList string) (tp.Fork, string, error) {
+	sourceBranch, err := dbo.SelectPostBranch(sourcePostId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return tp.Fork{}, "", ErrNoPost
+	} else if err != nil {
+		return tp.Fork{}, "", err
+	}
+
+	// Only the current holder of the source post's branch can fork
+	window, branch, err := branchCandidates(dbo, hash)
+	if err != nil {
+		return tp.Fork{}, "", err
+	}
+	hashIndex, role := findCandidate(hash, window)
+	if hashIndex == -1 {
+		return tp.Fork{}, "", ErrUnknownHash
+	} else if role != tp.ROLE_HEAD || branch != sourceBranch {
+		return tp.Fork{}, "", ErrForkNotHolder
+	}
+
+	fork, rawPasscode, err := ForkChain(
+		dbo, sourcePostId, FORK_HOLDER, wordList)
+	if err != nil {
+		return tp.Fork{}, "", err
+	}
+	cipher, err := encryptPasscode(rawPasscode, hash)
+	return fork, cipher, err
+}
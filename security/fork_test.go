@@ -0,0 +1,76 @@
+package security
+
+import (
+	"os"
+	"testing"
+
+	mock "github.com/georgejmx/whisper-blog/utils"
+	w "github.com/georgejmx/whisper-blog/words"
+)
+
+/* Checks that only the current holder of a post's branch can fork from it,
+and that they are given the genesis passcode of the fork */
+func TestHolderForkChain(t *testing.T) {
+	os.Setenv("AES_SPLICE_INDEX", "28")
+	os.Setenv("AES_IV", "snooping6is9bad0")
+	controller := &mock.MockController{}
+
+	fork, cipher, err := HolderForkChain(
+		controller, mock.MockPost.Id, mock.MockHashes[0], "")
+	if err != nil || fork.Authority != FORK_HOLDER || fork.Branch != 1 {
+		t.Fatalf("unable to fork as current holder: %v", err)
+	}
+	passcode, _ := DecryptCipher(mock.MockHashes[0], cipher)
+	if len(passcode) != 12 {
+		t.Logf("incorrect genesis passcode decrypted: %s", passcode)
+		t.Fail()
+	}
+
+	cases := []struct {
+		postId int
+		hash   string
+		err    error
+	}{
+		{mock.MockPost.Id, mock.MockHashes[1], ErrForkNotHolder},
+		{mock.MockPost.Id, mock.InvalidMockHashes[0], ErrUnknownHash},
+		{99, mock.MockHashes[0], ErrNoPost},
+	}
+	for _, test := range cases {
+		_, _, err = HolderForkChain(controller, test.postId, test.hash, "")
+		if err != test.err {
+			t.Logf("expected %v, found %v", test.err, err)
+			t.Fail()
+		}
+	}
+}
+
+/* Checks that an admin fork returns the raw genesis passcode, and can only
+choose a word list that exists */
+func TestForkChain(t *testing.T) {
+	controller := &mock.MockController{}
+	fork, passcode, err := ForkChain(
+		controller, mock.MockPost.Id, FORK_ADMIN, w.DEFAULT_LIST)
+	if err != nil || fork.Id != 1 || len(passcode) != 12 ||
+		fork.WordList != w.DEFAULT_LIST {
+		t.Logf("unable to fork as admin: %v", err)
+		t.Fail()
+	}
+	if _, _, err = ForkChain(controller, 99, FORK_ADMIN, ""); err != ErrNoPost {
+		t.Logf("expected no post error, found %v", err)
+		t.Fail()
+	}
+	_, _, err = ForkChain(controller, mock.MockPost.Id, FORK_ADMIN, "missing")
+	if err != w.ErrUnknownList {
+		t.Logf("expected unknown list error, found %v", err)
+		t.Fail()
+	}
+
+	// A list too short to describe a post cannot be chosen
+	short, _ := w.ParseList("short", []byte("calm\nbold"))
+	w.Register(short)
+	_, _, err = ForkChain(controller, mock.MockPost.Id, FORK_ADMIN, "short")
+	if err != w.ErrTooFewWords {
+		t.Logf("expected too few words error, found %v", err)
+		t.Fail()
+	}
+}
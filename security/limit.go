@@ -0,0 +1,43 @@
+package security
+
+import (
+	"sync"
+	"time"
+)
+
+// Limits how many requests each client can make in a window of time, for
+// routes that are costly enough to need more than the global rate limit.
+// Counts are kept in memory and cleared as each window ends
+type ClientLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	start  time.Time
+	counts map[string]int
+}
+
+/* Creates a limiter allowing each client *limit* requests in every window. A
+limit below 1 allows every request */
+func NewClientLimiter(limit int, window time.Duration) *ClientLimiter {
+	return &ClientLimiter{limit: limit, window: window,
+		counts: map[string]int{}}
+}
+
+/* Counts a request by the client, determining whether it is within the limit
+of the current window */
+func (l *ClientLimiter) Allow(clientHash string, now time.Time) bool {
+	if l == nil || l.limit < 1 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.start) >= l.window {
+		l.start = now
+		l.counts = map[string]int{}
+	}
+	if l.counts[clientHash] >= l.limit {
+		return false
+	}
+	l.counts[clientHash]++
+	return true
+}
@@ -0,0 +1,35 @@
+package security
+
+import (
+	"testing"
+	"time"
+)
+
+/* Checks that each client is limited separately, and that the limit resets
+once the window ends */
+func TestClientLimiter(t *testing.T) {
+	now := time.Date(2024, 6, 4, 12, 0, 0, 0, time.UTC)
+	limiter := NewClientLimiter(2, time.Minute)
+	for i, allowed := range []bool{true, true, false} {
+		if limiter.Allow("a", now) != allowed {
+			t.Logf("expected request %d of client a allowed: %v", i, allowed)
+			t.Fail()
+		}
+	}
+	if !limiter.Allow("b", now) {
+		t.Log("expected another client to have its own limit")
+		t.Fail()
+	}
+	if !limiter.Allow("a", now.Add(time.Minute)) {
+		t.Log("expected the limit to reset in the next window")
+		t.Fail()
+	}
+
+	// Without a positive limit every request is allowed
+	var unset *ClientLimiter
+	if !unset.Allow("a", now) || !NewClientLimiter(0, time.Minute).Allow(
+		"a", now) {
+		t.Log("expected no limit to allow every request")
+		t.Fail()
+	}
+}
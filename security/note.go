@@ -0,0 +1,109 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	tp "github.com/georgejmx/whisper-blog/types"
+)
+
+// Errors returned when a private note cannot be read
+var (
+	ErrNoteMissing = errors.New("no note was left for this passcode")
+	ErrNoteBad     = errors.New("note could not be opened")
+	ErrNoteRotated = errors.New(
+		"the note was discarded when this passcode was rotated")
+)
+
+/* Seals a private note for the next holder under a key derived from their raw
+passcode. Only the hash of the passcode is stored, so the server is unable to
+open the note once the passcode has been handed on */
+func SealNote(rawPasscode, note string) (string, error) {
+	return sealHex("note", rawPasscode, note)
+}
+
+/* Opens a note sealed by *SealNote*, failing if the raw passcode is not the
+one it was sealed under */
+func OpenNote(rawPasscode, sealedHex string) (string, error) {
+	note, err := openHex("note", rawPasscode, sealedHex)
+	if err != nil {
+		return "", ErrNoteBad
+	}
+	return note, nil
+}
+
+/* Reads the note left for the holder of the provided raw passcode, which
+proves that they hold it. A note is sealed under the passcode it was left for,
+and the server never sees the raw passcode again, so rotating the passcode
+discards the note rather than sealing it again */
+func ReadHolderNote(
+	dbo tp.ControllerTemplate, rawPasscode string) (string, error) {
+	passcode, err := dbo.SelectPasscode(RawToHash(rawPasscode))
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUnknownHash
+	} else if err != nil {
+		return "", err
+	} else if passcode.Note != "" {
+		return OpenNote(rawPasscode, passcode.Note)
+	}
+
+	// Explaining why a note is missing if the passcode has been rotated
+	rotations, err := dbo.SelectRotationCount(passcode.Id, time.Time{})
+	if err != nil {
+		return "", err
+	} else if rotations > 0 {
+		return "", ErrNoteRotated
+	}
+	return "", ErrNoteMissing
+}
+
+/* Encrypts plaintext under a key derived from the secret, returning the random
+nonce followed by the ciphertext in hex. The purpose salts the key so that it
+differs from any hash of the secret kept in the database */
+func sealHex(purpose, secret, plaintext string) (string, error) {
+	aead, err := newAead(purpose, secret)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return hex.EncodeToString(sealed), nil
+}
+
+/* Decrypts the output of *sealHex*, failing if it was sealed for a different
+purpose or secret, or has been tampered with */
+func openHex(purpose, secret, sealedHex string) (string, error) {
+	aead, err := newAead(purpose, secret)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := hex.DecodeString(sealedHex)
+	if err != nil {
+		return "", err
+	} else if len(sealed) < aead.NonceSize() {
+		return "", errors.New("sealed data too short")
+	}
+	nonceSize := aead.NonceSize()
+	plaintext, err := aead.Open(
+		nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	return string(plaintext), err
+}
+
+/* Creates the authenticated cipher keyed by the purpose and secret */
+func newAead(purpose, secret string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(purpose + ":" + secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,48 @@
+package security
+
+import (
+	"strings"
+	"testing"
+
+	mock "github.com/georgejmx/whisper-blog/utils"
+)
+
+/* Checks that a note only opens with the passcode it was sealed under, and is
+not kept in plaintext */
+func TestSealAndOpenNote(t *testing.T) {
+	note := "you always ask the right questions"
+	sealed, err := SealNote("aBcD3fGh1jKl", note)
+	if err != nil || strings.Contains(sealed, note) {
+		t.Fatalf("unable to seal note: %v", err)
+	}
+
+	opened, err := OpenNote("aBcD3fGh1jKl", sealed)
+	if err != nil || opened != note {
+		t.Logf("expected %q, found %q with %v", note, opened, err)
+		t.Fail()
+	}
+	if _, err = OpenNote("aBcD3fGh1jKm", sealed); err != ErrNoteBad {
+		t.Logf("expected note to not open with wrong passcode, found %v", err)
+		t.Fail()
+	}
+	if _, err = OpenNote("aBcD3fGh1jKl", sealed[:20]); err != ErrNoteBad {
+		t.Logf("expected truncated note to not open, found %v", err)
+		t.Fail()
+	}
+
+	// Sealing is randomised so equal notes look different
+	if resealed, _ := SealNote("aBcD3fGh1jKl", note); resealed == sealed {
+		t.Log("expected sealing to use a fresh nonce")
+		t.Fail()
+	}
+}
+
+/* Checks that a passcode with no stored hash cannot read notes */
+func TestReadHolderNoteUnknown(t *testing.T) {
+	controller := &mock.MockController{}
+	_, err := ReadHolderNote(controller, "notapasscode")
+	if err != ErrUnknownHash {
+		t.Logf("expected unknown hash error, found %v", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,148 @@
+package security
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/bits"
+	"os"
+	"strconv"
+
+	tp "github.com/georgejmx/whisper-blog/types"
+	u "github.com/georgejmx/whisper-blog/utils"
+)
+
+// Ways that a dormant chain can be revived
+const (
+	REVIVAL_WORK  = "work"
+	REVIVAL_TOKEN = "token"
+)
+
+// Errors returned when a dormant chain cannot be revived
+var (
+	ErrNotDormant = errors.New("chain is not dormant enough to be revived")
+	ErrRevival    = errors.New(
+		"revival needs a valid proof of work or revival token")
+)
+
+/* Determines whether a branch has gone without a new passcode for the
+configured number of days, so that anyone can revive it. Never true when
+revival is disabled */
+func IsDormant(dbo tp.ControllerTemplate, branch int) (bool, error) {
+	days, _ := strconv.Atoi(os.Getenv("REVIVAL_DAYS"))
+	if days <= 0 {
+		return false, nil
+	}
+	lastPostTime, err := dbo.SelectLatestTimestamp(branch)
+	if err != nil {
+		return false, err
+	}
+	return u.TimeSincePost(true, lastPostTime) >= days, nil
+}
+
+/* Gets the proof of work challenge for reviving a dormant branch, along with
+the number of leading zero bits that a solution needs. The challenge is
+derived from the latest passcode of the branch, so it changes as soon as the
+branch is revived and each solution only works once */
+func RevivalChallenge(
+	dbo tp.ControllerTemplate, branch int) (string, int, error) {
+	isDormant, err := IsDormant(dbo, branch)
+	if err != nil {
+		return "", 0, err
+	} else if !isDormant {
+		return "", 0, ErrNotDormant
+	}
+	window, err := candidateWindow(dbo, branch)
+	if err != nil {
+		return "", 0, err
+	}
+	difficulty, _ := strconv.Atoi(os.Getenv("REVIVAL_WORK"))
+	return RawToHash(fmt.Sprintf("revival:%d:%s", branch, window[0].Hash)),
+		difficulty, nil
+}
+
+/* Validates a post reviving a dormant branch, either by a nonce that solves
+the proof of work challenge or by the hash of an unused revival token. The
+token is not used up until the post is stored by *InsertRevivalPost*, so a
+revival that fails later can be retried */
+func ValidateRevival(dbo tp.ControllerTemplate,
+	branch int, method, nonce, hash string) error {
+	// The hash encrypts the next passcode, so must be a full length hash
+	if len(hash) != 64 {
+		return ErrRevival
+	}
+	challenge, difficulty, err := RevivalChallenge(dbo, branch)
+	if err != nil {
+		return err
+	}
+
+	switch method {
+	case REVIVAL_WORK:
+		if !SolvesChallenge(challenge, nonce, difficulty) {
+			return ErrRevival
+		}
+		return nil
+	case REVIVAL_TOKEN:
+		isUnused, err := dbo.SelectRevivalTokenUnused(hash)
+		if err != nil {
+			return err
+		} else if !isUnused {
+			return ErrRevival
+		}
+		return nil
+	}
+	return ErrRevival
+}
+
+/* Stores a revival post. A revival by token consumes the token in the same
+step, so that each token revives a chain only once. Returns the id of the
+post */
+func InsertRevivalPost(dbo tp.ControllerTemplate, post tp.Post) (int, error) {
+	if post.Revival != REVIVAL_TOKEN {
+		return dbo.InsertPost(post)
+	}
+	postId, err := dbo.InsertRevivalPost(post, post.Hash)
+	if err != nil {
+		return 0, err
+	} else if postId == 0 {
+		return 0, ErrRevival
+	}
+	return postId, nil
+}
+
+/* Determines whether the sha256 hash of the challenge and nonce, separated by
+a colon, starts with at least *difficulty* zero bits */
+func SolvesChallenge(challenge, nonce string, difficulty int) bool {
+	if nonce == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(challenge + ":" + nonce))
+	zeros := 0
+	for _, b := range sum {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeros >= difficulty
+}
+
+/* Issues a one-time revival token, storing only its hash. Once a chain is
+dormant the token can be used as the hash of a revival post */
+func IssueRevivalToken(dbo tp.ControllerTemplate) (string, error) {
+	rawToken := u.GenerateRawPasscode() + u.GenerateRawPasscode()
+	return rawToken, dbo.InsertRevivalToken(RawToHash(rawToken))
+}
+
+/* Sets the new randomly generated hash of a revived branch, issued by the
+revival post with id *postId*, returning it encrypted with the hash provided
+by the reviver, the same as *SetHashAndRetrieveCipher* */
+func SetRevivalHashAndRetrieveCipher(dbo tp.ControllerTemplate,
+	hash, note string, branch, postId int) (string, error) {
+	rawPasscode := u.GenerateRawPasscode()
+	if err := insertNextHash(
+		dbo, hash, rawPasscode, note, branch, postId); err != nil {
+		return "", err
+	}
+	return encryptPasscode(rawPasscode, hash)
+}
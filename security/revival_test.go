@@ -0,0 +1,97 @@
+package security
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	mock "github.com/georgejmx/whisper-blog/utils"
+)
+
+/* Checks that the mock chain, last posted to a week ago, is only dormant when
+the revival period has passed */
+func TestIsDormant(t *testing.T) {
+	defer os.Setenv("REVIVAL_DAYS", "30")
+	controller := &mock.MockController{}
+	expected := map[string]bool{"0": false, "5": true, "10": false}
+	for days, dormant := range expected {
+		os.Setenv("REVIVAL_DAYS", days)
+		isDormant, err := IsDormant(controller, 0)
+		if isDormant != dormant || err != nil {
+			t.Logf("expected dormant %v after %s days, found %v %v", dormant,
+				days, isDormant, err)
+			t.Fail()
+		}
+	}
+}
+
+/* Checks that a revival by token is only stored while the token is unused,
+and that a revival by proof of work needs no token */
+func TestInsertRevivalPost(t *testing.T) {
+	controller := &mock.MockController{}
+	post := mock.MockPost
+	post.Revival = REVIVAL_TOKEN
+	post.Hash = mock.InvalidMockHashes[1]
+	if _, err := InsertRevivalPost(controller, post); err != ErrRevival {
+		t.Logf("expected revival error for unknown token, found %v", err)
+		t.Fail()
+	}
+	post.Hash = RawToHash(mock.MockRevivalToken)
+	if _, err := InsertRevivalPost(controller, post); err != nil {
+		t.Logf("unable to insert revival post by token: %v", err)
+		t.Fail()
+	}
+	post.Revival = REVIVAL_WORK
+	post.Hash = mock.InvalidMockHashes[1]
+	if _, err := InsertRevivalPost(controller, post); err != nil {
+		t.Logf("unable to insert revival post by work: %v", err)
+		t.Fail()
+	}
+}
+
+/* Checks that a dormant chain can be revived by solving the proof of work or
+with an unused revival token, and by nothing else */
+func TestValidateRevival(t *testing.T) {
+	defer os.Setenv("REVIVAL_DAYS", "30")
+	os.Setenv("REVIVAL_DAYS", "10")
+	os.Setenv("REVIVAL_WORK", "8")
+	controller := &mock.MockController{}
+	reviverHash := mock.InvalidMockHashes[1]
+	err := ValidateRevival(controller, 0, REVIVAL_WORK, "1", reviverHash)
+	if err != ErrNotDormant {
+		t.Fatalf("expected not dormant error, found %v", err)
+	}
+
+	// Solving the challenge by brute force
+	os.Setenv("REVIVAL_DAYS", "5")
+	challenge, difficulty, err := RevivalChallenge(controller, 0)
+	if err != nil || difficulty != 8 {
+		t.Fatalf("unable to get revival challenge: %v", err)
+	}
+	nonce := 0
+	for !SolvesChallenge(challenge, strconv.Itoa(nonce), difficulty) {
+		nonce++
+	}
+
+	tokenHash := RawToHash(mock.MockRevivalToken)
+	cases := []struct {
+		method, nonce, hash string
+		err                 error
+	}{
+		{REVIVAL_WORK, strconv.Itoa(nonce), reviverHash, nil},
+		{REVIVAL_WORK, "", reviverHash, ErrRevival},
+		{REVIVAL_WORK, strconv.Itoa(nonce), "short", ErrRevival},
+		{REVIVAL_TOKEN, "", tokenHash, nil},
+		{REVIVAL_TOKEN, "", reviverHash, ErrRevival},
+		{"prayer", "", reviverHash, ErrRevival},
+	}
+	for _, test := range cases {
+		err = ValidateRevival(
+			controller, 0, test.method, test.nonce, test.hash)
+		if err != test.err {
+			t.Logf("expected %v for %s revival, found %v", test.err,
+				test.method, err)
+			t.Fail()
+		}
+	}
+}
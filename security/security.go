@@ -8,34 +8,84 @@ import (
 	"errors"
 	"os"
 	"strconv"
+	"time"
 
 	tp "github.com/georgejmx/whisper-blog/types"
 	u "github.com/georgejmx/whisper-blog/utils"
 )
 
+// Errors returned when validation fails, each recorded as a security event
+var (
+	ErrUnknownHash = errors.New("a: hash will never have ability to make post")
+	ErrHashTiming  = errors.New("b: hash failed validation timing")
+	ErrHashExpired = errors.New("c: hash has expired")
+	ErrHashReacted = errors.New("hash has already reacted")
+	ErrOwnPost     = errors.New(
+		"you do not have gravitas to react on your own post")
+	ErrAdminKey = errors.New("invalid admin key")
+	ErrNotHead  = errors.New(
+		"only the current holder can rotate their passcode")
+	ErrRotationLimit = errors.New("passcode has been rotated too often today")
+	ErrGenesisToken  = errors.New("genesis post requires a valid genesis token")
+)
+
+// Errors returned when the current holder cannot forfeit
+var (
+	ErrForfeitNotHead = errors.New("only the current holder can forfeit")
+	ErrForfeited      = errors.New("current holder has already forfeited")
+)
+
+// Kinds of security event that can be recorded
+const (
+	EVENT_FAILED_VALIDATION = "failed_validation"
+	EVENT_REUSED_REACTION   = "reused_reaction"
+	EVENT_OUT_OF_WINDOW     = "out_of_window"
+	EVENT_EXPIRED           = "expired_passcode"
+)
+
 /* Function to validate the provided hash against the **Chain Law**, determining
 whether a lawful post can be made */
 func ValidateHash(dbo tp.ControllerTemplate, hash string) (bool, error) {
-	// Grabbing stored hashes and latest timestamp
-	storedHashes, err := dbo.SelectCandidateHashes()
-	lastPostTime, err2 := dbo.SelectLatestTimestamp()
+	hashIndex, _, err := ValidateHashIndex(dbo, hash)
+	return hashIndex != -1, err
+}
+
+/* Validates the provided hash as above, returning the index and role of the
+candidate that is making the post, or -1 if no lawful post can be made */
+func ValidateHashIndex(
+	dbo tp.ControllerTemplate, hash string) (int, string, error) {
+	// Grabbing stored hashes and latest timestamp of the branch
+	window, branch, err := branchCandidates(dbo, hash)
 	if err != nil {
-		return false, err
-	} else if err2 != nil {
-		return false, err2
+		return -1, "", err
+	}
+	lastPostTime, err := dbo.SelectLatestTimestamp(branch)
+	if err != nil {
+		return -1, "", err
 	}
 
 	// Validating the Chain Law
-	hashIndex := findHashIndex(hash, storedHashes)
+	hashIndex, role := findCandidate(hash, window)
 	if hashIndex == -1 {
-		return false, errors.New("a: hash will never have ability to make post")
+		return -1, "", ErrUnknownHash
+	}
+	passcode, err := dbo.SelectPasscode(hash)
+	if err != nil {
+		return -1, "", err
+	} else if hasExpired(passcode, passcode.PostExpires, "POST_EXPIRY") {
+		return -1, "", ErrHashExpired
+	}
+	isForfeited, err := dbo.SelectHeadForfeited(branch)
+	if err != nil {
+		return -1, "", err
 	}
-	if isValTime := u.ValidateHashTiming(lastPostTime, hashIndex); !isValTime {
-		return false, errors.New("b: hash failed validation timing")
+	isValTime := u.ValidateHashTiming(lastPostTime, hashIndex, isForfeited)
+	if !isValTime {
+		return -1, "", ErrHashTiming
 	}
 
 	// We have a valid and correctly timed hash
-	return true, nil
+	return hashIndex, role, nil
 }
 
 /* Function to validate a reaction hash against the **Chain Law**, determining
@@ -49,25 +99,45 @@ func ValidateReactionHash(
 	}
 
 	// Performing db operations
-	storedHashes, err := dbo.SelectCandidateHashes()
+	window, _, err := branchCandidates(dbo, hash)
 	postReactionHashes, err2 := dbo.SelectPostReactionHashes(postId)
 	if err != nil || err2 != nil {
 		return false, 2, err
 	}
 
-	// Finding whether this hash has already been validated
-	if findHashIndex(hash, postReactionHashes) >= 0 {
-		return false, 2, errors.New("hash has already reacted")
+	// Finding whether this hash has already been validated, among every
+	// hash that has reacted to the post
+	if containsHash(hash, postReactionHashes) {
+		return false, 2, ErrHashReacted
+	}
+
+	// Finding if this hash is a candidate, and determining gravitas. Only
+	// candidates that can react need their passcode
+	candidateIndex, role := findCandidate(hash, window)
+	if candidateIndex == -1 || role == tp.ROLE_HEAD {
+		return candidateGravitas(tp.Passcode{}, candidateIndex, role)
+	}
+	passcode, err := dbo.SelectPasscode(hash)
+	if err != nil {
+		return false, 2, err
 	}
+	return candidateGravitas(passcode, candidateIndex, role)
+}
 
-	// Finding if this hash is a candidate, and determining gravitas
-	candidateHashIndex := findHashIndex(hash, storedHashes)
-	if candidateHashIndex == -1 {
+/* Determines the gravitas of a reaction by a passcode from its place in the
+candidate window, which is the same on every post it has yet to react to */
+func candidateGravitas(passcode tp.Passcode,
+	candidateIndex int, role string) (bool, int, error) {
+	if candidateIndex == -1 {
 		return false, 2, nil
-	} else if candidateHashIndex == 0 {
-		return false, 0, errors.New(
-			"you do not have gravitas to react on your own post")
-	} else if candidateHashIndex == 4 {
+	} else if role == tp.ROLE_HEAD {
+		return false, 0, ErrOwnPost
+	}
+
+	// Expired passcodes can no longer react
+	if hasExpired(passcode, passcode.ReactExpires, "REACT_EXPIRY") {
+		return false, 2, ErrHashExpired
+	} else if role == tp.ROLE_GENESIS {
 		return true, 1, nil
 	}
 
@@ -75,20 +145,304 @@ func ValidateReactionHash(
 	return true, 6, nil
 }
 
-/* Sets the new randomly generated hash by inserting into the database. Returns
-A string which is the new raw text symmetrically encrypted */
-func SetHashAndRetrieveCipher(dbo tp.ControllerTemplate,
-	isGenesis bool, prevHash string) (string, error) {
-	spliceInd, _ := strconv.ParseInt(os.Getenv("AES_SPLICE_INDEX"), 10, 64)
+/* Records a security event against the client when *err* is a validation
+failure. Other errors, such as failed db operations, are not recorded */
+func RecordFailure(
+	dbo tp.ControllerTemplate, clientHash string, err error) error {
+	var kind string
+	switch {
+	case errors.Is(err, ErrUnknownHash), errors.Is(err, ErrOwnPost),
+		errors.Is(err, ErrAdminKey), errors.Is(err, ErrSessionInvalid),
+		errors.Is(err, ErrNotHead), errors.Is(err, ErrGenesisToken),
+		errors.Is(err, ErrReadToken), errors.Is(err, ErrNotAuthor),
+		errors.Is(err, ErrForfeitNotHead), errors.Is(err, ErrForkNotHolder),
+		errors.Is(err, ErrRevival):
+		kind = EVENT_FAILED_VALIDATION
+	case errors.Is(err, ErrHashReacted):
+		kind = EVENT_REUSED_REACTION
+	case errors.Is(err, ErrHashTiming), errors.Is(err, ErrNotDormant):
+		kind = EVENT_OUT_OF_WINDOW
+	case errors.Is(err, ErrHashExpired):
+		kind = EVENT_EXPIRED
+	default:
+		return nil
+	}
 
-	// If genesis use hash('genesis') else use the previous hash
-	if isGenesis {
-		prevHash = RawToHash("gen6si9")
+	return dbo.InsertSecurityEvent(tp.SecurityEvent{
+		Kind:       kind,
+		Reason:     err.Error(),
+		ClientHash: clientHash,
+	})
+}
+
+/* Gets the remaining time that a client is locked out for, which is zero if
+they are not locked out. Lockout grows with each failure in the past day */
+func CheckLockout(
+	dbo tp.ControllerTemplate, clientHash string) (time.Duration, error) {
+	threshold, _ := strconv.Atoi(os.Getenv("LOCKOUT_THRESHOLD"))
+	failureTimes, err := dbo.SelectFailureTimes(
+		clientHash, time.Now().Add(-time.Hour*24))
+	if err != nil || len(failureTimes) == 0 {
+		return 0, err
+	}
+
+	// Lockout runs from the latest failure
+	lockout := u.LockoutDuration(len(failureTimes), threshold)
+	if remaining := time.Until(failureTimes[0].Add(lockout)); remaining > 0 {
+		return remaining, nil
 	}
+	return 0, nil
+}
 
+/* Sets the new randomly generated hash by inserting into the database, issued
+by the post with id *postId*. Returns A string which is the new raw text
+symmetrically encrypted with the previous hash. For the genesis post this is
+the hash of the genesis token. A non-empty note is sealed for the next holder
+alongside the new hash */
+func SetHashAndRetrieveCipher(dbo tp.ControllerTemplate,
+	prevHash, note string, postId int) (string, error) {
 	// Generating passcode and hash
 	rawPasscode := u.GenerateRawPasscode()
-	dbo.InsertHash(RawToHash(rawPasscode))
+	branch, err := PasscodeBranch(dbo, prevHash)
+	if err != nil {
+		return "", err
+	}
+	err = insertNextHash(dbo, prevHash, rawPasscode, note, branch, postId)
+	if err != nil {
+		return "", err
+	}
+
+	return encryptPasscode(rawPasscode, prevHash)
+}
+
+/* Sets the new randomly generated hash as above, but splits the raw passcode
+into *n* shares for a group to hold, any *k* of which rebuild it. Returns each
+share in its own cipher, encrypted the same way as a single passcode */
+func SetHashAndRetrieveShares(dbo tp.ControllerTemplate,
+	prevHash, note string, postId, n, k int) ([]string, error) {
+	// Splitting before inserting, so that bad parameters do not set a hash
+	rawPasscode := u.GenerateRawPasscode()
+	shares, err := SplitSecret([]byte(rawPasscode), n, k)
+	if err != nil {
+		return nil, err
+	}
+	branch, err := PasscodeBranch(dbo, prevHash)
+	if err != nil {
+		return nil, err
+	}
+	err = insertNextHash(dbo, prevHash, rawPasscode, note, branch, postId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Encrypting the hex encoding of each share
+	ciphers := make([]string, len(shares))
+	for i, share := range shares {
+		ciphers[i], err = encryptPasscode(hex.EncodeToString(share), prevHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ciphers, nil
+}
+
+/* Rebuilds a raw passcode from hex encoded shares, checking that it is a
+candidate passcode so that guesses are treated as failed attempts */
+func CombinePasscodeShares(
+	dbo tp.ControllerTemplate, hexShares []string) (string, error) {
+	shares := make([][]byte, len(hexShares))
+	for i, hexShare := range hexShares {
+		share, err := hex.DecodeString(hexShare)
+		if err != nil {
+			return "", ErrShareBad
+		}
+		shares[i] = share
+	}
+
+	rawPasscode, err := CombineShares(shares)
+	if err != nil {
+		return "", err
+	}
+	hash := RawToHash(string(rawPasscode))
+	window, _, err := branchCandidates(dbo, hash)
+	if err != nil {
+		return "", err
+	}
+	if candidateIndex, _ := findCandidate(hash, window); candidateIndex == -1 {
+		return "", ErrUnknownHash
+	}
+	return string(rawPasscode), nil
+}
+
+/* Issues a one-time genesis token, storing only its hash. The token authorises
+the genesis post and keys the cipher of the first passcode, so it is only
+ever shown to the admin who generates it */
+func IssueGenesisToken(dbo tp.ControllerTemplate) (string, error) {
+	rawToken := u.GenerateRawPasscode() + u.GenerateRawPasscode()
+	return rawToken, dbo.InsertGenesisToken(RawToHash(rawToken))
+}
+
+/* Validates the hash provided with the genesis post against the unused genesis
+tokens. The token is not used up until the post is stored by
+*InsertGenesisPost*, so a genesis post that fails later can be retried */
+func ValidateGenesisHash(dbo tp.ControllerTemplate, hash string) error {
+	isUnused, err := dbo.SelectGenesisTokenUnused(hash)
+	if err != nil {
+		return err
+	} else if !isUnused {
+		return ErrGenesisToken
+	}
+	return nil
+}
+
+/* Stores the genesis post, consuming its genesis token in the same step so
+that each token starts the chain only once. Returns the id of the post */
+func InsertGenesisPost(dbo tp.ControllerTemplate, post tp.Post) (int, error) {
+	postId, err := dbo.InsertGenesisPost(post, post.Hash)
+	if err != nil {
+		return 0, err
+	} else if postId == 0 {
+		return 0, ErrGenesisToken
+	}
+	return postId, nil
+}
+
+/* Lets the current holder replace their passcode if they suspect it leaked,
+without advancing the chain. The head passcode row is given a fresh hash and
+the new raw passcode is returned encrypted with the old hash, the same as
+*SetHashAndRetrieveCipher*. Subject to the Chain Law and a rotation limit */
+func RotateHeadHash(dbo tp.ControllerTemplate,
+	hash, clientHash string) (string, error) {
+	window, branch, err := branchCandidates(dbo, hash)
+	if err != nil {
+		return "", err
+	}
+	lastPostTime, err := dbo.SelectLatestTimestamp(branch)
+	if err != nil {
+		return "", err
+	}
+
+	// Only the current holder can rotate, and only within their window
+	hashIndex, role := findCandidate(hash, window)
+	if hashIndex == -1 {
+		return "", ErrUnknownHash
+	} else if role != tp.ROLE_HEAD {
+		return "", ErrNotHead
+	} else if !u.ValidateHashTiming(lastPostTime, hashIndex, false) {
+		return "", ErrHashTiming
+	}
+
+	// Replacing the hash and recording the rotation, limiting how often the
+	// same passcode row can be rotated
+	passcodeId, err := dbo.SelectPasscodeId(hash)
+	if err != nil {
+		return "", err
+	}
+	limit, _ := strconv.Atoi(os.Getenv("ROTATION_LIMIT"))
+	rawPasscode := u.GenerateRawPasscode()
+	isRotated, err := dbo.RotateHash(passcodeId, RawToHash(rawPasscode),
+		clientHash, time.Now().Add(-time.Hour*24), limit)
+	if err != nil {
+		return "", err
+	} else if !isRotated {
+		return "", ErrRotationLimit
+	}
+	return encryptPasscode(rawPasscode, hash)
+}
+
+/* Lets the current holder give up their exclusive window when they know they
+cannot post. Every timing window is then treated as expired, so previous
+holders on their branch can post straight away. The forfeit is recorded
+against the head post of the branch, which issued the passcode, where it is
+shown on the chain */
+func ForfeitHead(dbo tp.ControllerTemplate, hash string) error {
+	window, branch, err := branchCandidates(dbo, hash)
+	if err != nil {
+		return err
+	}
+	hashIndex, role := findCandidate(hash, window)
+	if hashIndex == -1 {
+		return ErrUnknownHash
+	} else if role != tp.ROLE_HEAD {
+		return ErrForfeitNotHead
+	}
+
+	isForfeited, err := dbo.SelectHeadForfeited(branch)
+	if err != nil {
+		return err
+	} else if isForfeited {
+		return ErrForfeited
+	}
+	passcodeId, err := dbo.SelectPasscodeId(hash)
+	if err != nil {
+		return err
+	}
+	postId, err := dbo.SelectBranchHeadId(branch)
+	if err != nil {
+		return err
+	}
+	return dbo.InsertForfeit(passcodeId, postId)
+}
+
+/* Marks the previous passcode as consumed then inserts the hash of the next
+one on the provided branch, issued by the post with id *postId*, which expires
+for posting and reacting after the configured days. Any note is stored sealed
+under the next raw passcode */
+func insertNextHash(dbo tp.ControllerTemplate,
+	prevHash, rawPasscode, note string, branch, postId int) error {
+	if err := dbo.ConsumeHash(prevHash); err != nil {
+		return err
+	}
+	hash := RawToHash(rawPasscode)
+	err := dbo.InsertHash(hash, branch, postId,
+		expiryFromNow("POST_EXPIRY"), expiryFromNow("REACT_EXPIRY"))
+	if err != nil || note == "" {
+		return err
+	}
+	sealed, err := SealNote(rawPasscode, note)
+	if err != nil {
+		return err
+	}
+	return dbo.InsertHashNote(hash, sealed)
+}
+
+/* Gets the expiry of a passcode issued now, from the number of days in the
+provided config variable. Zero if the passcode should never expire */
+func expiryFromNow(daysEnv string) time.Time {
+	days, _ := strconv.Atoi(os.Getenv(daysEnv))
+	if days <= 0 {
+		return time.Time{}
+	}
+	return time.Now().AddDate(0, 0, days)
+}
+
+/* Determines whether a passcode has been retired or passed the provided
+expiry. Passcodes issued before expiry was recorded fall back to their issue
+time plus the configured number of days */
+func hasExpired(passcode tp.Passcode, expires time.Time, daysEnv string) bool {
+	if passcode.Retired {
+		return true
+	}
+	if expires.IsZero() {
+		days, _ := strconv.Atoi(os.Getenv(daysEnv))
+		if days <= 0 || passcode.Issued.IsZero() {
+			return false
+		}
+		expires = passcode.Issued.AddDate(0, 0, days)
+	}
+	return time.Now().After(expires)
+}
+
+/* Retires passcodes that have expired for both posting and reacting, so stale
+rows are marked in the database. Returns the number retired */
+func RetireExpiredPasscodes(dbo tp.ControllerTemplate) (int64, error) {
+	return dbo.RetireExpiredHashes(time.Now())
+}
+
+/* Encrypts a raw passcode using part of the previous hash as the key, so that
+only its holder can read the new passcode */
+func encryptPasscode(rawPasscode, prevHash string) (string, error) {
+	spliceInd, _ := strconv.ParseInt(os.Getenv("AES_SPLICE_INDEX"), 10, 64)
 
 	// Initialising cipher with the old hash
 	bPlaintext := u.Pkcs5Padding([]byte(rawPasscode), aes.BlockSize, 12)
@@ -110,14 +464,46 @@ func RawToHash(raw string) string {
 	return hex.EncodeToString(hashBytes[:])
 }
 
-/* Finds if the provided hash is a candidate, and if so the index */
-func findHashIndex(providedHash string, candidates [5]string) int {
-	for ind, value := range candidates {
-		if value == providedHash {
-			return ind
+/* Selects the configured number of candidate hashes of a branch, giving each
+its role. The head is first and genesis is last, with recent holders between
+*/
+func candidateWindow(
+	dbo tp.ControllerTemplate, branch int) ([]tp.Candidate, error) {
+	hashes, err := dbo.SelectCandidateHashes(branch, u.CandidateWindow())
+	if err != nil {
+		return nil, err
+	}
+	window := make([]tp.Candidate, len(hashes))
+	for ind, hash := range hashes {
+		window[ind] = tp.Candidate{
+			Hash: hash, Role: u.CandidateRole(ind, len(hashes))}
+	}
+	return window, nil
+}
+
+/* Finds if the provided hash is a candidate, and if so its index and role.
+Empty slots of the window never match */
+func findCandidate(
+	providedHash string, window []tp.Candidate) (int, string) {
+	if providedHash == "" {
+		return -1, ""
+	}
+	for ind, candidate := range window {
+		if candidate.Hash == providedHash {
+			return ind, candidate.Role
+		}
+	}
+	return -1, ""
+}
+
+/* Finds if the provided hash is among the hashes */
+func containsHash(providedHash string, hashes []string) bool {
+	for _, hash := range hashes {
+		if hash == providedHash {
+			return true
 		}
 	}
-	return -1
+	return false
 }
 
 /* For use in integration tests, also a reference for the frontend js
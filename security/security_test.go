@@ -1,9 +1,12 @@
 package security
 
 import (
+	"errors"
 	"os"
 	"testing"
+	"time"
 
+	tp "github.com/georgejmx/whisper-blog/types"
 	mock "github.com/georgejmx/whisper-blog/utils"
 )
 
@@ -32,6 +35,27 @@ func TestValidateHash(t *testing.T) {
 	}
 }
 
+/* Checks that validating a hash gives the index and role of the candidate
+posting */
+func TestValidateHashIndex(t *testing.T) {
+	controller := &mock.MockController{}
+	expected := map[int]string{0: tp.ROLE_HEAD, 2: tp.ROLE_RECENT}
+	for hashIndex, expectedRole := range expected {
+		found, role, err := ValidateHashIndex(
+			controller, mock.MockHashes[hashIndex])
+		if err != nil || found != hashIndex || role != expectedRole {
+			t.Logf("expected index %d as %s, found %d as %s with %v",
+				hashIndex, expectedRole, found, role, err)
+			t.Fail()
+		}
+	}
+	found, _, _ := ValidateHashIndex(controller, mock.MockHashes[3])
+	if found != -1 {
+		t.Logf("expected no index for a mistimed hash, found %d", found)
+		t.Fail()
+	}
+}
+
 /* Checks that the hash validation function fails when expected */
 func TestValidateHashFailure(t *testing.T) {
 	controller := &mock.MockController{}
@@ -116,6 +140,82 @@ func TestValidateReactionHashFails(t *testing.T) {
 		t.Log("expected an error for an already used hash")
 		t.Fail()
 	}
+
+	// Checks that a duplicate reactor is caught however many have reacted
+	isValid, _, err = ValidateReactionHash(
+		controller, mock.MockHashes[2], mock.MockCrowdedPostId)
+	if err != ErrHashReacted || isValid {
+		t.Logf("expected a duplicate reactor on a crowded post, found %v", err)
+		t.Fail()
+	}
+}
+
+/* Checks that the candidate window follows its configured length, keeping the
+head first and the genesis holder last */
+func TestCandidateWindow(t *testing.T) {
+	controller := &mock.MockController{}
+	os.Setenv("CANDIDATE_WINDOW", "3")
+	defer os.Unsetenv("CANDIDATE_WINDOW")
+
+	window, err := candidateWindow(controller, 0)
+	if err != nil || len(window) != 3 {
+		t.Fatalf("expected a window of 3 candidates, found %v", window)
+	}
+	roles := []string{tp.ROLE_HEAD, tp.ROLE_RECENT, tp.ROLE_GENESIS}
+	for ind, candidate := range window {
+		if candidate.Role != roles[ind] {
+			t.Logf("expected %s at index %d, found %s", roles[ind], ind,
+				candidate.Role)
+			t.Fail()
+		}
+	}
+
+	// The genesis holder keeps its gravitas, and those beyond the window
+	// are no longer candidates
+	isValid, gravitas, err := ValidateReactionHash(
+		controller, mock.MockHashes[4], 1)
+	if err != nil || gravitas != 1 || !isValid {
+		t.Logf("expected genesis gravitas, found %d, %v", gravitas, err)
+		t.Fail()
+	}
+	isValid, _, err = ValidateReactionHash(controller, mock.MockHashes[2], 1)
+	if err != nil || isValid {
+		t.Logf("expected a hash beyond the window to be ignored, found %v", err)
+		t.Fail()
+	}
+}
+
+/* Checks that the genesis post can only be made with a genesis token */
+func TestValidateGenesisHash(t *testing.T) {
+	controller := &mock.MockController{}
+
+	for _, hash := range []string{
+		mock.MockHashes[4], mock.InvalidMockHashes[1], ""} {
+		err := ValidateGenesisHash(controller, hash)
+		if err != ErrGenesisToken {
+			t.Logf("expected genesis token error for %s, found %v", hash, err)
+			t.Fail()
+		}
+	}
+
+	token, err := IssueGenesisToken(controller)
+	if err != nil || len(token) != 24 {
+		t.Logf("incorrect genesis token issued: %s", token)
+		t.Fail()
+	}
+
+	// The token is only used up when the genesis post is stored
+	post := mock.MockPost
+	post.Hash = mock.MockHashes[4]
+	if _, err = InsertGenesisPost(controller, post); err != ErrGenesisToken {
+		t.Logf("expected genesis token error on insert, found %v", err)
+		t.Fail()
+	}
+	post.Hash = mock.MockGenesisToken
+	if _, err = InsertGenesisPost(controller, post); err != nil {
+		t.Logf("unable to insert genesis post: %v", err)
+		t.Fail()
+	}
 }
 
 /* Checks that storing and retrieving hashes behaves properly for both genesis
@@ -127,7 +227,7 @@ func TestSetHashAndRetrieveCipher(t *testing.T) {
 
 	controller := &mock.MockController{}
 	ciphercode, err := SetHashAndRetrieveCipher(
-		controller, false, mock.MockHashes[0])
+		controller, mock.MockHashes[0], "", mock.MockPost.Id)
 	if err != nil {
 		t.Logf("set hash function has thrown an error: %s", err)
 		t.Fail()
@@ -145,8 +245,14 @@ func TestSetHashAndRetrieveCipher(t *testing.T) {
 		t.Fail()
 	}
 
-	// Genesis post case
-	ciphercode, err = SetHashAndRetrieveCipher(controller, true, "")
+	// Genesis post case, keyed by the genesis token
+	err = ValidateGenesisHash(controller, mock.MockGenesisToken)
+	if err != nil {
+		t.Logf("genesis token validation failed: %s", err)
+		t.Fail()
+	}
+	ciphercode, err = SetHashAndRetrieveCipher(
+		controller, mock.MockGenesisToken, "", mock.MockPost.Id)
 	if err != nil {
 		t.Logf("set hash function has thrown an error at genesis: %s", err)
 		t.Fail()
@@ -155,7 +261,7 @@ func TestSetHashAndRetrieveCipher(t *testing.T) {
 		t.Fail()
 	}
 
-	passcode, err = DecryptCipher(RawToHash("gen6si9"), ciphercode)
+	passcode, err = DecryptCipher(mock.MockGenesisToken, ciphercode)
 	if err != nil {
 		t.Logf("decrypting cipher threw an error: %s", err)
 		t.Fail()
@@ -164,3 +270,183 @@ func TestSetHashAndRetrieveCipher(t *testing.T) {
 		t.Fail()
 	}
 }
+
+/* Checks that validation failures are recorded and that lockouts apply only to
+clients with repeated failures */
+func TestRecordFailureAndLockout(t *testing.T) {
+	os.Setenv("LOCKOUT_THRESHOLD", "5")
+	controller := &mock.MockController{}
+
+	// Validation failures are recorded, other errors are ignored
+	for _, failure := range []error{ErrUnknownHash, ErrHashTiming,
+		ErrHashReacted, errors.New("some db error")} {
+		err := RecordFailure(controller, mock.MockLockedClient, failure)
+		if err != nil {
+			t.Logf("unexpected error recording failure: %s", err)
+			t.Fail()
+		}
+	}
+
+	// The locked client has 10 recent failures so should be locked out
+	remaining, err := CheckLockout(controller, mock.MockLockedClient)
+	if err != nil || remaining <= 0 {
+		t.Logf("expected lockout, found %v with error %v", remaining, err)
+		t.Fail()
+	}
+
+	// A client with no failures should not be locked out
+	remaining, err = CheckLockout(controller, mock.MockHashes[0])
+	if err != nil || remaining != 0 {
+		t.Logf("expected no lockout, found %v with error %v", remaining, err)
+		t.Fail()
+	}
+}
+
+/* Checks that only the current holder can rotate their passcode, receiving the
+new passcode encrypted with their old hash */
+func TestRotateHeadHash(t *testing.T) {
+	os.Setenv("AES_SPLICE_INDEX", "28")
+	os.Setenv("AES_IV", "snooping6is9bad0")
+	os.Setenv("ROTATION_LIMIT", "3")
+	controller := &mock.MockController{}
+
+	ciphercode, err := RotateHeadHash(
+		controller, mock.MockHashes[0], mock.MockLockedClient)
+	if err != nil {
+		t.Fatalf("unexpected error rotating head hash: %s", err)
+	}
+	passcode, err := DecryptCipher(mock.MockHashes[0], ciphercode)
+	if err != nil || len(passcode) != 12 {
+		t.Logf("incorrect rotated passcode: %s", passcode)
+		t.Fail()
+	}
+
+	// Previous holders and unknown hashes cannot rotate
+	_, err = RotateHeadHash(controller, mock.MockHashes[1], "")
+	if err != ErrNotHead {
+		t.Logf("expected not head error, found %v", err)
+		t.Fail()
+	}
+	_, err = RotateHeadHash(controller, mock.InvalidMockHashes[0], "")
+	if err != ErrUnknownHash {
+		t.Logf("expected unknown hash error, found %v", err)
+		t.Fail()
+	}
+
+	// No rotations are allowed once the limit is reached
+	os.Setenv("ROTATION_LIMIT", "0")
+	_, err = RotateHeadHash(controller, mock.MockHashes[0], "")
+	if err != ErrRotationLimit {
+		t.Logf("expected rotation limit error, found %v", err)
+		t.Fail()
+	}
+}
+
+/* Checks that a passcode split into shares can only be rebuilt into a
+candidate passcode */
+func TestSetHashAndRetrieveShares(t *testing.T) {
+	os.Setenv("AES_SPLICE_INDEX", "28")
+	os.Setenv("AES_IV", "snooping6is9bad0")
+	controller := &mock.MockController{}
+
+	ciphers, err := SetHashAndRetrieveShares(
+		controller, mock.MockHashes[0], "", mock.MockPost.Id, 3, 2)
+	if err != nil || len(ciphers) != 3 {
+		t.Fatalf("unable to set hash and retrieve shares: %v", err)
+	}
+
+	// Decrypting two shares, which rebuild a passcode the mock does not hold
+	var shares []string
+	for _, ciphercode := range ciphers[1:] {
+		share, err := DecryptCipher(mock.MockHashes[0], ciphercode)
+		if err != nil || len(share) != 28 {
+			t.Fatalf("incorrect share decrypted: %s", share)
+		}
+		shares = append(shares, share)
+	}
+	_, err = CombinePasscodeShares(controller, shares)
+	if err != ErrUnknownHash {
+		t.Logf("expected unknown hash error, found %v", err)
+		t.Fail()
+	}
+
+	// Invalid parameters should fail before a hash is set
+	_, err = SetHashAndRetrieveShares(
+		controller, mock.MockHashes[0], "", mock.MockPost.Id, 2, 3)
+	if err != ErrShareParams {
+		t.Logf("expected share params error, found %v", err)
+		t.Fail()
+	}
+}
+
+/* Checks that expired passcodes can neither post nor react, with a distinct
+error, and that legacy passcodes fall back to their issue time */
+func TestPasscodeExpiry(t *testing.T) {
+	controller := &mock.MockController{}
+	mock.MockIsExpired = true
+	defer func() { mock.MockIsExpired = false }()
+
+	isValid, err := ValidateHash(controller, mock.MockHashes[0])
+	if isValid || err != ErrHashExpired {
+		t.Logf("expected expired error when posting, found %v", err)
+		t.Fail()
+	}
+	isValid, _, err = ValidateReactionHash(controller, mock.MockHashes[2], 1)
+	if isValid || err != ErrHashExpired {
+		t.Logf("expected expired error when reacting, found %v", err)
+		t.Fail()
+	}
+
+	// Passcodes without a stored expiry use the configured days
+	os.Setenv("POST_EXPIRY", "3")
+	legacy := tp.Passcode{Issued: time.Now().AddDate(0, 0, -4)}
+	if !hasExpired(legacy, legacy.PostExpires, "POST_EXPIRY") {
+		t.Log("expected legacy passcode to have expired")
+		t.Fail()
+	}
+	os.Setenv("POST_EXPIRY", "0")
+	if hasExpired(legacy, legacy.PostExpires, "POST_EXPIRY") {
+		t.Log("expected legacy passcode to never expire")
+		t.Fail()
+	}
+	legacy.Retired = true
+	if !hasExpired(legacy, legacy.PostExpires, "POST_EXPIRY") {
+		t.Log("expected retired passcode to have expired")
+		t.Fail()
+	}
+}
+
+/* Checks that only the current holder can forfeit, once, and that a forfeit
+opens every timing window */
+func TestForfeitHead(t *testing.T) {
+	controller := &mock.MockController{}
+	if err := ForfeitHead(controller, mock.MockHashes[0]); err != nil {
+		t.Logf("expected current holder to forfeit, found %v", err)
+		t.Fail()
+	}
+	err := ForfeitHead(controller, mock.MockHashes[1])
+	if err != ErrForfeitNotHead {
+		t.Logf("expected previous holder to be refused, found %v", err)
+		t.Fail()
+	}
+	err = ForfeitHead(controller, mock.InvalidMockHashes[0])
+	if err != ErrUnknownHash {
+		t.Logf("expected unknown hash to be refused, found %v", err)
+		t.Fail()
+	}
+
+	// The genesis hash cannot post yet, until the holder forfeits
+	if isValid, _ := ValidateHash(controller, mock.MockHashes[4]); isValid {
+		t.Fatal("genesis hash posted before forfeit")
+	}
+	mock.MockIsForfeited = true
+	defer func() { mock.MockIsForfeited = false }()
+	if isValid, err := ValidateHash(controller, mock.MockHashes[4]); !isValid {
+		t.Logf("expected genesis hash to post after forfeit, found %v", err)
+		t.Fail()
+	}
+	if err := ForfeitHead(controller, mock.MockHashes[0]); err != ErrForfeited {
+		t.Logf("expected second forfeit to be refused, found %v", err)
+		t.Fail()
+	}
+}
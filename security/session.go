@@ -0,0 +1,170 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	tp "github.com/georgejmx/whisper-blog/types"
+)
+
+// Errors returned when a session token cannot be used
+var (
+	ErrSessionInvalid = errors.New("invalid session token")
+	ErrSessionExpired = errors.New("session token has expired")
+	ErrSessionRevoked = errors.New(
+		"session token revoked as the chain has advanced or passcode rotated")
+	ErrNoSessionKeys = errors.New("no session signing key configured")
+)
+
+// A signing key for session tokens, identified so that keys can be rotated
+type sessionKey struct {
+	id     string
+	secret []byte
+}
+
+/* Exchanges a passcode hash for a signed session token, which can be used in
+place of the hash until it expires or the chain advances. Returns the token
+and its expiry */
+func IssueSessionToken(
+	dbo tp.ControllerTemplate, hash string) (string, time.Time, error) {
+	var expiry time.Time
+	keys := sessionKeys()
+	if len(keys) == 0 {
+		return "", expiry, ErrNoSessionKeys
+	}
+
+	// Only candidate hashes can hold a session
+	window, _, err := branchCandidates(dbo, hash)
+	if err != nil {
+		return "", expiry, err
+	}
+	hashIndex, _ := findCandidate(hash, window)
+	if len(hash) < 64 || hashIndex == -1 {
+		return "", expiry, ErrUnknownHash
+	}
+	passcodeId, err := dbo.SelectPasscodeId(hash)
+	if err != nil {
+		return "", expiry, err
+	}
+
+	// Payload is of the form 'key id:passcode id:candidate index:digest:expiry'
+	ttl, _ := strconv.Atoi(os.Getenv("SESSION_TTL"))
+	expiry = time.Now().Add(time.Duration(ttl) * time.Minute).
+		Truncate(time.Second)
+	payload := fmt.Sprintf("%s:%d:%d:%s:%d", keys[0].id, passcodeId,
+		hashIndex, hashDigest(hash), expiry.Unix())
+	return encodeSegment([]byte(payload)) + "." +
+		encodeSegment(signPayload(keys[0].secret, payload)), expiry, nil
+}
+
+/* Resolves a session token back into the passcode hash it was issued for.
+Fails if the token is forged, expired or the chain has since advanced */
+func ResolveSessionToken(
+	dbo tp.ControllerTemplate, token string) (string, error) {
+	payload, err := verifySessionToken(token)
+	if err != nil {
+		return "", err
+	}
+
+	// Parsing the verified payload
+	fields := strings.Split(payload, ":")
+	passcodeId, err := strconv.Atoi(fields[1])
+	hashIndex, err2 := strconv.Atoi(fields[2])
+	expiryUnix, err3 := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil || err2 != nil || err3 != nil {
+		return "", ErrSessionInvalid
+	} else if time.Now().Unix() > expiryUnix {
+		return "", ErrSessionExpired
+	}
+
+	// The passcode must not have been rotated, and must hold the same
+	// candidate position as when issued
+	hash, err := dbo.SelectPasscodeHash(passcodeId)
+	if err != nil {
+		return "", err
+	} else if hashDigest(hash) != fields[3] {
+		return "", ErrSessionRevoked
+	}
+	window, _, err := branchCandidates(dbo, hash)
+	if err != nil {
+		return "", err
+	} else if candidateIndex, _ := findCandidate(
+		hash, window); candidateIndex != hashIndex {
+		return "", ErrSessionRevoked
+	}
+	return hash, nil
+}
+
+/* Checks the token signature against any configured key, returning the
+payload if valid */
+func verifySessionToken(token string) (string, error) {
+	segments := strings.Split(token, ".")
+	if len(segments) != 2 {
+		return "", ErrSessionInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(segments[0])
+	signature, err2 := base64.RawURLEncoding.DecodeString(segments[1])
+	if err != nil || err2 != nil {
+		return "", ErrSessionInvalid
+	}
+
+	// Finding the key the token claims to be signed with
+	fields := strings.Split(string(payload), ":")
+	if len(fields) != 5 {
+		return "", ErrSessionInvalid
+	}
+	for _, key := range sessionKeys() {
+		if key.id == fields[0] && hmac.Equal(
+			signature, signPayload(key.secret, string(payload))) {
+			return string(payload), nil
+		}
+	}
+	return "", ErrSessionInvalid
+}
+
+/* Checks that at least one session signing key is configured, so that the
+server refuses to start without one */
+func CheckSessionKeys() error {
+	if len(sessionKeys()) == 0 {
+		return ErrNoSessionKeys
+	}
+	return nil
+}
+
+/* Parses the configured signing keys, of the form 'id:secret,id:secret'. The
+first key signs new tokens, the rest are only accepted until rotated out */
+func sessionKeys() []sessionKey {
+	var keys []sessionKey
+	for _, entry := range strings.Split(os.Getenv("SESSION_KEYS"), ",") {
+		id, secret, found := strings.Cut(strings.TrimSpace(entry), ":")
+		if found && id != "" && secret != "" {
+			keys = append(keys, sessionKey{id: id, secret: []byte(secret)})
+		}
+	}
+	return keys
+}
+
+/* Gets a short digest identifying the hash a token was issued for, so that
+rotating the passcode revokes its tokens */
+func hashDigest(hash string) string {
+	return RawToHash(hash)[:16]
+}
+
+/* Signs a token payload with HMAC-SHA256 */
+func signPayload(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+/* Encodes a token segment so it is safe in headers and urls */
+func encodeSegment(segment []byte) string {
+	return base64.RawURLEncoding.EncodeToString(segment)
+}
@@ -0,0 +1,84 @@
+package security
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	mock "github.com/georgejmx/whisper-blog/utils"
+)
+
+/* Checks that a session token resolves back into the hash it was issued for */
+func TestSessionToken(t *testing.T) {
+	os.Setenv("SESSION_KEYS", "k2:newer6secret, k1:older6secret")
+	os.Setenv("SESSION_TTL", "30")
+	controller := &mock.MockController{}
+
+	for _, hash := range []string{mock.MockHashes[0], mock.MockHashes[4]} {
+		token, expiry, err := IssueSessionToken(controller, hash)
+		if err != nil || expiry.IsZero() {
+			t.Fatalf("unexpected error issuing session token: %v", err)
+		}
+		resolved, err := ResolveSessionToken(controller, token)
+		if err != nil || resolved != hash {
+			t.Logf("expected %s, found %s with error %v", hash, resolved, err)
+			t.Fail()
+		}
+	}
+
+	// Only candidate hashes can hold a session
+	if _, _, err := IssueSessionToken(
+		controller, mock.InvalidMockHashes[0]); err != ErrUnknownHash {
+		t.Logf("expected unknown hash error, found %v", err)
+		t.Fail()
+	}
+}
+
+/* Checks that forged, expired and rotated out tokens are refused */
+func TestSessionTokenFailure(t *testing.T) {
+	os.Setenv("SESSION_KEYS", "k1:older6secret")
+	os.Setenv("SESSION_TTL", "30")
+	controller := &mock.MockController{}
+	token, _, _ := IssueSessionToken(controller, mock.MockHashes[1])
+
+	// Tampering with the payload should invalidate the signature
+	segments := strings.Split(token, ".")
+	forged := encodeSegment(
+		[]byte("k1:5:0:0123456789abcdef:9999999999")) + "." + segments[1]
+	_, err := ResolveSessionToken(controller, forged)
+	if err != ErrSessionInvalid {
+		t.Logf("expected forged token to be invalid, found %v", err)
+		t.Fail()
+	}
+
+	// Malformed or missing keys leave nothing to sign with
+	for _, keys := range []string{"", "k1", ":secret", " , "} {
+		os.Setenv("SESSION_KEYS", keys)
+		if err = CheckSessionKeys(); err != ErrNoSessionKeys {
+			t.Logf("expected no session keys from %q, found %v", keys, err)
+			t.Fail()
+		}
+	}
+
+	// Tokens signed by a rotated in key remain valid until rotated out
+	os.Setenv("SESSION_KEYS", "k2:newer6secret,k1:older6secret")
+	if _, err = ResolveSessionToken(controller, token); err != nil {
+		t.Logf("expected token signed by older key to be valid, found %v", err)
+		t.Fail()
+	}
+	os.Setenv("SESSION_KEYS", "k2:newer6secret")
+	_, err = ResolveSessionToken(controller, token)
+	if err != ErrSessionInvalid {
+		t.Logf("expected token signed by removed key to fail, found %v", err)
+		t.Fail()
+	}
+
+	// A token with no lifetime should have expired
+	os.Setenv("SESSION_TTL", "-1")
+	token, _, _ = IssueSessionToken(controller, mock.MockHashes[1])
+	_, err = ResolveSessionToken(controller, token)
+	if err != ErrSessionExpired {
+		t.Logf("expected expired token, found %v", err)
+		t.Fail()
+	}
+}
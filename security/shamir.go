@@ -0,0 +1,146 @@
+package security
+
+import (
+	"crypto/rand"
+	"errors"
+)
+
+// Limits on how a passcode can be split between a group
+const (
+	MIN_SHARES int = 2
+	MAX_SHARES int = 10
+)
+
+// Errors returned when sharing or combining fails
+var (
+	ErrShareParams = errors.New(
+		"shares must be 2 to 10, with a threshold from 2 to the shares")
+	ErrShareCount = errors.New("not enough shares to rebuild passcode")
+	ErrShareBad   = errors.New("shares are malformed or do not match")
+)
+
+// Log and exp tables for multiplication in GF(256), generated from 3
+var gfExp, gfLog = gfTables()
+
+/* Splits a secret into *n* shares such that any *k* of them rebuild it, while
+fewer reveal nothing. Each share is laid out as [k, x, y...] where y is one
+byte per secret byte */
+func SplitSecret(secret []byte, n, k int) ([][]byte, error) {
+	if err := ValidateShareParams(n, k); err != nil {
+		return nil, err
+	}
+
+	// Each share is a point at x = 1..n on a random degree k-1 polynomial
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, 2, len(secret)+2)
+		shares[i][0], shares[i][1] = byte(k), byte(i+1)
+	}
+
+	// One polynomial per secret byte, with the byte as the constant term
+	coefficients := make([]byte, k)
+	for _, secretByte := range secret {
+		coefficients[0] = secretByte
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			shares[i] = append(shares[i],
+				evaluatePolynomial(coefficients, shares[i][1]))
+		}
+	}
+	return shares, nil
+}
+
+/* Checks that *n* shares with a threshold of *k* can be made */
+func ValidateShareParams(n, k int) error {
+	if n < MIN_SHARES || n > MAX_SHARES || k < MIN_SHARES || k > n {
+		return ErrShareParams
+	}
+	return nil
+}
+
+/* Rebuilds a secret from at least the threshold number of shares, using
+Lagrange interpolation at x = 0 */
+func CombineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) == 0 || len(shares[0]) < 3 {
+		return nil, ErrShareBad
+	}
+	k, length := int(shares[0][0]), len(shares[0])
+	if len(shares) < k {
+		return nil, ErrShareCount
+	}
+
+	// Shares must agree on threshold and length, with distinct x values
+	seen := make(map[byte]bool)
+	for _, share := range shares[:k] {
+		if len(share) != length || int(share[0]) != k || share[1] == 0 ||
+			seen[share[1]] {
+			return nil, ErrShareBad
+		}
+		seen[share[1]] = true
+	}
+
+	secret := make([]byte, length-2)
+	for i := range secret {
+		var value byte
+		for j, share := range shares[:k] {
+			// Lagrange basis polynomial for this share, evaluated at 0
+			basis := byte(1)
+			for m, other := range shares[:k] {
+				if m != j {
+					basis = gfMul(basis,
+						gfDiv(other[1], other[1]^share[1]))
+				}
+			}
+			value ^= gfMul(share[i+2], basis)
+		}
+		secret[i] = value
+	}
+	return secret, nil
+}
+
+/* Evaluates a polynomial with the provided coefficients at x, in GF(256) */
+func evaluatePolynomial(coefficients []byte, x byte) byte {
+	var result byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		result = gfMul(result, x) ^ coefficients[i]
+	}
+	return result
+}
+
+/* Multiplies two elements of GF(256) */
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])+int(gfLog[b]))%255]
+}
+
+/* Divides two elements of GF(256), where b is non zero */
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])-int(gfLog[b])+255)%255]
+}
+
+/* Builds the exp and log tables of GF(256) with the AES polynomial */
+func gfTables() ([255]byte, [256]byte) {
+	var exp [255]byte
+	var log [256]byte
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		exp[i] = x
+		log[x] = byte(i)
+
+		// Multiplying by the generator 3, reducing by x^8+x^4+x^3+x+1
+		high := x & 0x80
+		doubled := x << 1
+		if high != 0 {
+			doubled ^= 0x1b
+		}
+		x ^= doubled
+	}
+	return exp, log
+}
@@ -0,0 +1,74 @@
+package security
+
+import (
+	"bytes"
+	"testing"
+)
+
+/* Checks that any threshold sized subset of shares rebuilds the secret */
+func TestSplitAndCombineShares(t *testing.T) {
+	secret := []byte("aBcD3fGh1jKl")
+	shares, err := SplitSecret(secret, 5, 3)
+	if err != nil || len(shares) != 5 {
+		t.Fatalf("unable to split secret: %v", err)
+	}
+
+	subsets := [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}}
+	for _, subset := range subsets {
+		var chosen [][]byte
+		for _, ind := range subset {
+			chosen = append(chosen, shares[ind])
+		}
+		combined, err := CombineShares(chosen)
+		if err != nil || !bytes.Equal(combined, secret) {
+			t.Logf("subset %v rebuilt %q with error %v", subset, combined, err)
+			t.Fail()
+		}
+	}
+}
+
+/* Checks that too few, duplicated or malformed shares are refused */
+func TestCombineSharesFailure(t *testing.T) {
+	shares, _ := SplitSecret([]byte("aBcD3fGh1jKl"), 4, 3)
+
+	if _, err := CombineShares(shares[:2]); err != ErrShareCount {
+		t.Logf("expected share count error, found %v", err)
+		t.Fail()
+	}
+	duplicated := [][]byte{shares[0], shares[1], shares[1]}
+	if _, err := CombineShares(duplicated); err != ErrShareBad {
+		t.Logf("expected bad share error for duplicates, found %v", err)
+		t.Fail()
+	}
+	truncated := [][]byte{shares[0], shares[1], shares[2][:5]}
+	if _, err := CombineShares(truncated); err != ErrShareBad {
+		t.Logf("expected bad share error for truncation, found %v", err)
+		t.Fail()
+	}
+
+	// Parameters outside of the allowed range
+	for _, params := range [][2]int{{1, 1}, {3, 4}, {11, 2}, {4, 1}} {
+		_, err := SplitSecret([]byte("x"), params[0], params[1])
+		if err == nil {
+			t.Logf("expected error splitting with %v", params)
+			t.Fail()
+		}
+	}
+}
+
+/* Checks the GF(256) tables give inverse multiplication and division */
+func TestGaloisField(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		for _, b := range []byte{1, 3, 0x53, 0xca, 0xff} {
+			if gfDiv(gfMul(byte(a), b), b) != byte(a) {
+				t.Fatalf("division does not invert multiplication for %d", a)
+			}
+		}
+	}
+
+	// A known product in the AES field
+	if gfMul(0x53, 0xca) != 0x01 {
+		t.Log("expected 0x53 and 0xca to be inverses")
+		t.Fail()
+	}
+}
@@ -0,0 +1,104 @@
+package security
+
+import (
+	"database/sql"
+	"errors"
+
+	tp "github.com/georgejmx/whisper-blog/types"
+	u "github.com/georgejmx/whisper-blog/utils"
+)
+
+/* Gets what the passcode with the provided hash can do, for its holder. Gives
+its role in the candidate window of its branch, whether and when it can post,
+the gravitas it would carry on each post and the posts it has reacted to.
+Hashes with no stored passcode are unknown, so nothing is revealed about
+passcodes that the caller does not hold */
+func PasscodeStatus(
+	dbo tp.ControllerTemplate, hash string) (tp.PasscodeStatus, error) {
+	status := tp.PasscodeStatus{HashIndex: -1}
+	if len(hash) < 64 {
+		return status, ErrUnknownHash
+	}
+	passcode, err := dbo.SelectPasscode(hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return status, ErrUnknownHash
+	} else if err != nil {
+		return status, err
+	}
+
+	// Finding the role of the passcode, and when it can post
+	window, branch, err := branchCandidates(dbo, hash)
+	if err != nil {
+		return status, err
+	}
+	status.Branch = branch
+	status.HashIndex, status.Role = findCandidate(hash, window)
+	if status.HashIndex != -1 &&
+		!hasExpired(passcode, passcode.PostExpires, "POST_EXPIRY") {
+		if err = setPostTiming(dbo, &status, passcode); err != nil {
+			return status, err
+		}
+	}
+
+	// Finding the gravitas on each post that it has yet to react to. This
+	// depends only on its place in the window, so is worked out once
+	_, gravitas, err := candidateGravitas(
+		passcode, status.HashIndex, status.Role)
+	if errors.Is(err, ErrOwnPost) || errors.Is(err, ErrHashExpired) {
+		gravitas = 0
+	} else if err != nil {
+		return status, err
+	}
+	if status.Reacted, err = dbo.SelectReactedPostIds(hash); err != nil {
+		return status, err
+	}
+	posts, err := dbo.SelectPosts()
+	if err != nil {
+		return status, err
+	}
+	status.Gravitas = []tp.PostGravitas{}
+	for _, post := range posts {
+		if u.IsSealed(post.Reveal) || containsId(post.Id, status.Reacted) {
+			continue
+		}
+		status.Gravitas = append(status.Gravitas,
+			tp.PostGravitas{PostId: post.Id, Gravitas: gravitas})
+	}
+	return status, nil
+}
+
+/* Sets whether a candidate can post now under Chain Law, or otherwise when
+its tier opens. A tier that opens after the passcode expires never does */
+func setPostTiming(dbo tp.ControllerTemplate, status *tp.PasscodeStatus,
+	passcode tp.Passcode) error {
+	lastPostTime, err := dbo.SelectLatestTimestamp(status.Branch)
+	if err != nil {
+		return err
+	}
+	isForfeited, err := dbo.SelectHeadForfeited(status.Branch)
+	if err != nil {
+		return err
+	}
+
+	tiers := u.ChainLaw(lastPostTime, isForfeited)
+	if status.HashIndex >= len(tiers) {
+		return nil
+	}
+	tier := tiers[status.HashIndex]
+	status.CanPost = tier.Eligible
+	if !tier.Eligible && (passcode.PostExpires.IsZero() ||
+		tier.OpensAt.Before(passcode.PostExpires)) {
+		status.PostsFrom = &tier.OpensAt
+	}
+	return nil
+}
+
+/* Finds if the provided id is among the ids */
+func containsId(providedId int, ids []int) bool {
+	for _, id := range ids {
+		if id == providedId {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package security
+
+import (
+	"testing"
+
+	tp "github.com/georgejmx/whisper-blog/types"
+	mock "github.com/georgejmx/whisper-blog/utils"
+)
+
+/* Checks that the status of a passcode gives its role, when it can post and
+the gravitas it carries, without revealing unknown passcodes */
+func TestPasscodeStatus(t *testing.T) {
+	controller := &mock.MockController{}
+
+	// The head can post, but carries no gravitas on its own post
+	status, err := PasscodeStatus(controller, mock.MockHashes[0])
+	if err != nil || status.Role != tp.ROLE_HEAD || !status.CanPost ||
+		len(status.Gravitas) != 1 || status.Gravitas[0].Gravitas != 0 {
+		t.Logf("unexpected head status: %+v, %v", status, err)
+		t.Fail()
+	}
+
+	// A recent holder within their tier can post and react
+	status, err = PasscodeStatus(controller, mock.MockHashes[2])
+	if err != nil || status.Role != tp.ROLE_RECENT || !status.CanPost ||
+		len(status.Reacted) != 0 || status.Gravitas[0].Gravitas != 6 {
+		t.Logf("unexpected recent status: %+v, %v", status, err)
+		t.Fail()
+	}
+
+	// A holder whose tier opens after their passcode expires never can
+	status, err = PasscodeStatus(controller, mock.MockHashes[3])
+	if err != nil || status.CanPost || status.PostsFrom != nil ||
+		len(status.Reacted) != 1 || len(status.Gravitas) != 0 {
+		t.Logf("unexpected status of reacted holder: %+v, %v", status, err)
+		t.Fail()
+	}
+
+	for _, hash := range mock.InvalidMockHashes {
+		if _, err = PasscodeStatus(controller, hash); err != ErrUnknownHash {
+			t.Logf("expected unknown hash error for %q, found %v", hash, err)
+			t.Fail()
+		}
+	}
+}
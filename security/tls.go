@@ -0,0 +1,104 @@
+package security
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Holds the served certificate, so that it can be swapped without a restart
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+}
+
+/* Creates a reloader serving the certificate and key at the provided paths.
+Returns an error if they cannot be loaded */
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	cr := &CertReloader{certFile: certFile, keyFile: keyFile}
+	return cr, cr.Reload()
+}
+
+/* Loads the certificate and key from disk again, keeping the current
+certificate if loading fails */
+func (cr *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.mu.Unlock()
+	return nil
+}
+
+/* Supplies the current certificate to each tls handshake */
+func (cr *CertReloader) GetCertificate(
+	*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+/* Reloads the certificate whenever the process receives SIGHUP, so renewed
+certificates are picked up without dropping connections */
+func (cr *CertReloader) ReloadOnSighup() {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			if err := cr.Reload(); err != nil {
+				log.Printf("unable to reload tls certificate: %v", err)
+			} else {
+				log.Print("reloaded tls certificate")
+			}
+		}
+	}()
+}
+
+/* Builds the tls config served with, from the configured minimum version and
+cipher preferences. Ciphers only apply to tls 1.2, as 1.3 suites are fixed */
+func TlsConfig(cr *CertReloader) (*tls.Config, error) {
+	tlsConf := &tls.Config{GetCertificate: cr.GetCertificate}
+
+	switch os.Getenv("TLS_MIN_VERSION") {
+	case "", "1.2":
+		tlsConf.MinVersion = tls.VersionTLS12
+	case "1.3":
+		tlsConf.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf(
+			"unsupported tls minimum version %s", os.Getenv("TLS_MIN_VERSION"))
+	}
+
+	// Mapping configured cipher names onto their ids, refusing insecure ones
+	names := os.Getenv("TLS_CIPHERS")
+	if names == "" {
+		return tlsConf, nil
+	}
+	for _, name := range strings.Split(names, ",") {
+		id, ok := findCipherSuite(strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure tls cipher %s", name)
+		}
+		tlsConf.CipherSuites = append(tlsConf.CipherSuites, id)
+	}
+	return tlsConf, nil
+}
+
+/* Finds the id of a secure cipher suite from its name */
+func findCipherSuite(name string) (uint16, bool) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,145 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* Writes a freshly generated self-signed certificate and key for localhost to
+the provided directory, returning their paths */
+func writeSelfSignedCert(t *testing.T, dir, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %s", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(
+		rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unable to create certificate: %s", err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+		0600)
+	return certFile, keyFile
+}
+
+/* Checks that a server using the tls config serves the loaded certificate,
+and serves a replacement after reloading */
+func TestCertReloader(t *testing.T) {
+	os.Setenv("TLS_MIN_VERSION", "1.2")
+	os.Setenv("TLS_CIPHERS", "")
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSignedCert(t, dir, "first")
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("unable to load certificate: %s", err)
+	}
+	tlsConf, err := TlsConfig(reloader)
+	if err != nil {
+		t.Fatalf("unable to build tls config: %s", err)
+	}
+
+	// Serving over tls with our config alone, accepting the self-signed cert
+	server := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(204) }))
+	server.Listener = tls.NewListener(server.Listener, tlsConf)
+	server.Start()
+	defer server.Close()
+	url := strings.Replace(server.URL, "http://", "https://", 1)
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}}
+
+	for _, name := range []string{"first", "second"} {
+		if name != "first" {
+			writeSelfSignedCert(t, dir, name)
+			if err = reloader.Reload(); err != nil {
+				t.Fatalf("unable to reload certificate: %s", err)
+			}
+		}
+
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("unable to make tls request: %s", err)
+		}
+		served := resp.TLS.PeerCertificates[0].Subject.CommonName
+		if served != name {
+			t.Logf("expected certificate %s, found %s", name, served)
+			t.Fail()
+		}
+	}
+
+	// A failed reload should keep serving the current certificate
+	os.WriteFile(certFile, []byte("not a certificate"), 0600)
+	if err = reloader.Reload(); err == nil {
+		t.Log("expected error reloading an invalid certificate")
+		t.Fail()
+	} else if cert, _ := reloader.GetCertificate(nil); cert == nil {
+		t.Log("certificate was dropped after a failed reload")
+		t.Fail()
+	}
+}
+
+/* Checks that the minimum version and cipher preferences are applied, and that
+unknown values are refused */
+func TestTlsConfig(t *testing.T) {
+	reloader := &CertReloader{}
+
+	os.Setenv("TLS_MIN_VERSION", "1.3")
+	os.Setenv("TLS_CIPHERS", "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, "+
+		"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384")
+	tlsConf, err := TlsConfig(reloader)
+	if err != nil {
+		t.Fatalf("unexpected error building tls config: %s", err)
+	} else if tlsConf.MinVersion != tls.VersionTLS13 ||
+		len(tlsConf.CipherSuites) != 2 ||
+		tlsConf.CipherSuites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384 {
+		t.Logf("config not applied: %v %v",
+			tlsConf.MinVersion, tlsConf.CipherSuites)
+		t.Fail()
+	}
+
+	// Insecure ciphers and old versions should be refused
+	os.Setenv("TLS_CIPHERS", "TLS_RSA_WITH_RC4_128_SHA")
+	if _, err = TlsConfig(reloader); err == nil {
+		t.Log("expected error for an insecure cipher")
+		t.Fail()
+	}
+	os.Setenv("TLS_CIPHERS", "")
+	os.Setenv("TLS_MIN_VERSION", "1.0")
+	if _, err = TlsConfig(reloader); err == nil {
+		t.Log("expected error for an unsupported minimum version")
+		t.Fail()
+	}
+}
@@ -13,6 +13,25 @@ type Post struct {
 	Time        time.Time  `json:"time"`
 	Hash        string     `json:"hash,omitempty"`
 	Reactions   []Reaction `json:"reactions,omitempty"`
+	Shares      int        `json:"shares,omitempty"`
+	Threshold   int        `json:"threshold,omitempty"`
+	Note        string     `json:"note,omitempty"`
+	Reveal      *time.Time `json:"reveal,omitempty"`
+	Sealed      bool       `json:"sealed,omitempty"`
+	PasscodeId  int        `json:"passcodeId,omitempty"`
+	Erased      bool       `json:"erased,omitempty"`
+	HashIndex   int        `json:"hashIndex"`
+	Role        string     `json:"role,omitempty"`
+	Provenance  string     `json:"provenance,omitempty"`
+	Forfeited   *time.Time `json:"forfeited,omitempty"`
+	Branch      int        `json:"branch"`
+	ParentId    int        `json:"parentId,omitempty"`
+	Branches    int        `json:"branches,omitempty"`
+	Chain       int        `json:"chain"`
+	Forks       []int      `json:"forks,omitempty"`
+	Revival     string     `json:"revival,omitempty"`
+	Nonce       string     `json:"nonce,omitempty"`
+	WordList    string     `json:"-"`
 }
 
 // Represents the HTML data of a post on the UI
@@ -25,11 +44,36 @@ type PostHtmlContent struct {
 	Contents    string
 	Author      string
 	Reactions   []Reaction
+	IsSealed    bool
+	RevealTime  string
+	Provenance  string
+	Forfeited   string
+	Branch      int
+	ParentTitle string
+	Forks       []int
+	ForkedFrom  string
+	SourceChain int
+}
+
+// A post within the tree of branches, holding the posts that continue from it
+type PostNode struct {
+	Post     Post       `json:"post"`
+	Children []PostNode `json:"children,omitempty"`
 }
 
 // Contains above data needed for HTML content structure
 type HtmlPostContainer struct {
 	HtmlPosts []PostHtmlContent
+	Stats     ChainStats
+}
+
+// Counts how each link of the chain was made, showing how often the chain has
+// needed rescuing by holders other than the current one
+type ChainStats struct {
+	Links    int `json:"links"`
+	PassedOn int `json:"passedOn"`
+	Rescued  int `json:"rescued"`
+	Revived  int `json:"revived"`
 }
 
 // Contains above data needed for HTML content structure
@@ -44,22 +88,157 @@ type Reaction struct {
 	Descriptor   string `json:"descriptor"`
 	Gravitas     int    `json:"gravitas"`
 	GravitasHash string `json:"hash,omitempty"`
+	DescriptorId int    `json:"descriptorId,omitempty"`
 	Colour       string
 	ColourDark   string
 }
 
+// A single descriptor of a post, at its position among the post's descriptors
+type Descriptor struct {
+	Id       int    `json:"id"`
+	PostId   int    `json:"postId"`
+	Position int    `json:"position"`
+	Word     string `json:"word"`
+}
+
+// Roles of the passcodes in the candidate window, which can post or react
+const (
+	ROLE_HEAD    = "head"    // the current holder
+	ROLE_RECENT  = "recent"  // previous holders, most recent first
+	ROLE_GENESIS = "genesis" // the first passcode of the branch
+)
+
+// A passcode hash in the candidate window, along with its role. The hash of
+// an empty slot is empty
+type Candidate struct {
+	Hash string
+	Role string
+}
+
+// A tier of the candidate window under Chain Law, with the moment that it
+// can post from and whether it can post now
+type LawTier struct {
+	Tier      string    `json:"tier"`
+	HashIndex int       `json:"hashIndex"`
+	Role      string    `json:"role"`
+	OpensAt   time.Time `json:"opensAt"`
+	Eligible  bool      `json:"eligible"`
+}
+
+// What a passcode can do, as seen by its holder. A passcode outside the
+// candidate window has no role and cannot post
+type PasscodeStatus struct {
+	Branch    int            `json:"branch"`
+	Role      string         `json:"role,omitempty"`
+	HashIndex int            `json:"hashIndex"`
+	CanPost   bool           `json:"canPost"`
+	PostsFrom *time.Time     `json:"postsFrom,omitempty"`
+	Gravitas  []PostGravitas `json:"gravitas"`
+	Reacted   []int          `json:"reacted"`
+}
+
+// The gravitas that a reaction to a post would carry. Zero when the reaction
+// would be refused
+type PostGravitas struct {
+	PostId   int `json:"postId"`
+	Gravitas int `json:"gravitas"`
+}
+
+// Represents a chain forked from a source post in its parent chain. Posts of
+// the fork continue from a fresh genesis passcode on its own branch
+type Fork struct {
+	Id           int       `json:"id"`
+	SourcePostId int       `json:"sourcePostId"`
+	SourceChain  int       `json:"sourceChain"`
+	Branch       int       `json:"branch"`
+	Authority    string    `json:"authority"`
+	Time         time.Time `json:"time"`
+	WordList     string    `json:"wordList,omitempty"`
+}
+
+// Represents a recorded security event, such as a failed passcode attempt
+type SecurityEvent struct {
+	Id         int       `json:"id"`
+	Kind       string    `json:"kind"`
+	Reason     string    `json:"reason"`
+	ClientHash string    `json:"clientHash"`
+	Time       time.Time `json:"time"`
+}
+
+// Represents a passcode row and its lifetime. Zero times are unset, where an
+// unset expiry means the passcode does not expire
+type Passcode struct {
+	Id           int
+	Hash         string
+	Issued       time.Time
+	Consumed     time.Time
+	PostExpires  time.Time
+	ReactExpires time.Time
+	Retired      bool
+	Note         string
+	Branch       int
+	PostId       int
+}
+
+// Represents an audited rotation of a passcode by its holder
+type PasscodeRotation struct {
+	Id         int       `json:"id"`
+	PasscodeId int       `json:"passcodeId"`
+	ClientHash string    `json:"clientHash"`
+	Time       time.Time `json:"time"`
+}
+
 // A template for an object that performs database interactions
 type ControllerTemplate interface {
 	Init() error
 	SelectPosts() ([]Post, error)
 	SelectPostReactions(postId int) ([]Reaction, error)
-	SelectLatestTimestamp() (time.Time, error)
-	SelectCandidateHashes() ([5]string, error)
-	SelectPostReactionHashes(postId int) ([5]string, error)
-	SelectDescriptors(postId int) (string, error)
+	SelectLatestTimestamp(branch int) (time.Time, error)
+	SelectCandidateHashes(branch, n int) ([]string, error)
+	SelectPostReactionHashes(postId int) ([]string, error)
+	SelectReactedPostIds(hash string) ([]int, error)
+	SelectPostDescriptors(postId int) ([]Descriptor, error)
+	SelectRecentDescriptors(limit int) ([]string, error)
 	SelectAnonReactionCount(postId int) (int, error)
-	InsertPost(post Post) error
+	InsertPost(post Post) (int, error)
 	InsertReaction(reaction Reaction) error
-	InsertHash(hash string) error
+	SelectPostReveal(postId int) (time.Time, error)
+	SelectPostPasscodeId(postId int) (int, error)
+	ErasePost(postId int, title, contents string, dropReactions bool) error
+	InsertHash(hash string, branch, postId int,
+		postExp, reactExp time.Time) error
+	SelectPasscode(hash string) (Passcode, error)
+	SelectPasscodeBranch(hash string) (int, error)
+	SelectNextBranch() (int, error)
+	SelectBranchHeadId(branch int) (int, error)
+	ConsumeHash(hash string) error
+	InsertHashNote(hash, note string) error
+	RetireExpiredHashes(now time.Time) (int64, error)
+	InsertGenesisToken(hash string) error
+	SelectGenesisTokenUnused(hash string) (bool, error)
+	InsertGenesisPost(post Post, tokenHash string) (int, error)
+	InsertRevivalToken(hash string) error
+	SelectRevivalTokenUnused(hash string) (bool, error)
+	InsertRevivalPost(post Post, tokenHash string) (int, error)
+	InsertReadToken(hash string) (int, error)
+	SelectReadTokenActive(hash string) (bool, error)
+	RevokeReadToken(id int) (bool, error)
+	InsertForfeit(passcodeId, postId int) error
+	SelectHeadForfeited(branch int) (bool, error)
+	SelectForfeits() (map[int]time.Time, error)
+	InsertFork(fork Fork, hash string, postExp, reactExp time.Time) (int, error)
+	SelectForks() ([]Fork, error)
+	SelectPostBranch(postId int) (int, error)
+	SelectPostWordList(postId int) (string, error)
+	SelectForkWordList(branch int) (string, error)
+	SelectPasscodeId(hash string) (int, error)
+	SelectPasscodeHash(id int) (string, error)
+	RotateHash(passcodeId int, hash, clientHash string, since time.Time,
+		limit int) (bool, error)
+	SelectRotationCount(passcodeId int, since time.Time) (int, error)
+	SelectRotations(limit int) ([]PasscodeRotation, error)
+	InsertSecurityEvent(event SecurityEvent) error
+	SelectSecurityEvents(limit int) ([]SecurityEvent, error)
+	SelectFailureTimes(clientHash string, since time.Time) ([]time.Time, error)
 	Clear() bool
 }
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tp "github.com/georgejmx/whisper-blog/types"
+)
+
+const (
+	ICS_TIME   = "20060102T150405Z" // times of an iCalendar file, in UTC
+	ICS_LENGTH = time.Hour          // how long each milestone event lasts
+	ICS_FOLD   = 75                 // longest line of an iCalendar file
+)
+
+/* Builds an iCalendar file of the upcoming Chain Law milestones of a branch,
+one event for each tier that has yet to open. The first is the end of the
+current holder's exclusive window. Event ids are derived from the head post,
+so a calendar replaces the events whenever the chain advances */
+func ChainLawCalendar(
+	branch int, headTime time.Time, tiers []tp.LawTier) string {
+	var ics strings.Builder
+	writeIcsLine(&ics, "BEGIN:VCALENDAR")
+	writeIcsLine(&ics, "VERSION:2.0")
+	writeIcsLine(&ics, "PRODID:-//whisper-blog//Chain Law//EN")
+	writeIcsLine(&ics, "CALSCALE:GREGORIAN")
+	writeIcsLine(&ics, "METHOD:PUBLISH")
+	writeIcsLine(&ics, "X-WR-CALNAME:"+
+		escapeIcsText(fmt.Sprintf("Chain Law, branch %d", branch)))
+
+	stamp := time.Now().UTC().Format(ICS_TIME)
+	for _, tier := range tiers {
+		if tier.Eligible {
+			continue
+		}
+		holder := tier.Tier
+		if tier.Role == tp.ROLE_GENESIS {
+			holder = "genesis holder"
+		}
+		summary := fmt.Sprintf("The %s can post", holder)
+		if tier.HashIndex == 1 {
+			summary = "Exclusive window ends, the previous holder can post"
+		}
+		writeIcsLine(&ics, "BEGIN:VEVENT")
+		writeIcsLine(&ics, fmt.Sprintf("UID:chain-law-%d-%d-%d@whisper-blog",
+			branch, headTime.Unix(), tier.HashIndex))
+		writeIcsLine(&ics, "DTSTAMP:"+stamp)
+		writeIcsLine(&ics, "DTSTART:"+tier.OpensAt.UTC().Format(ICS_TIME))
+		writeIcsLine(&ics,
+			"DTEND:"+tier.OpensAt.Add(ICS_LENGTH).UTC().Format(ICS_TIME))
+		writeIcsLine(&ics, "SUMMARY:"+escapeIcsText(summary))
+		writeIcsLine(&ics, "DESCRIPTION:"+escapeIcsText(fmt.Sprintf(
+			"Branch %d has had no post since %s. From now on the %s can "+
+				"post to continue the chain.", branch,
+			headTime.UTC().Format(time.RFC1123), holder)))
+		writeIcsLine(&ics, "TRANSP:TRANSPARENT")
+		writeIcsLine(&ics, "END:VEVENT")
+	}
+	writeIcsLine(&ics, "END:VCALENDAR")
+	return ics.String()
+}
+
+/* Writes a content line ended by CRLF, folding it onto continuation lines
+that begin with a space so that none is longer than allowed */
+func writeIcsLine(ics *strings.Builder, line string) {
+	for len(line) > ICS_FOLD {
+		// Never splitting a multi-byte character across lines
+		cut := ICS_FOLD
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		ics.WriteString(line[:cut] + "\r\n")
+		line = " " + line[cut:]
+	}
+	ics.WriteString(line + "\r\n")
+}
+
+/* Escapes the characters that are special in iCalendar text values */
+func escapeIcsText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`,
+		"\n", `\n`).Replace(text)
+}
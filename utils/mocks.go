@@ -1,6 +1,11 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
 	"time"
 
 	tp "github.com/georgejmx/whisper-blog/types"
@@ -9,6 +14,19 @@ import (
 // Mock database controller, used by all unit tests
 type MockController struct{}
 
+// Whether mock passcodes have expired, toggled by tests of expiry
+var MockIsExpired = false
+
+// Whether the mock current holder has forfeited, toggled by tests of forfeits
+var MockIsForfeited = false
+
+// Ids of the mock post that is still sealed, and of the mock post with more
+// attributed reactions than the candidate window
+const (
+	MockSealedPostId  = 2
+	MockCrowdedPostId = 3
+)
+
 // Data to populate this mock controller
 var (
 	MockPost = tp.Post{
@@ -38,6 +56,12 @@ var (
 		"PENULTIMATE4c7d659a2feaa0c55ad015a3bf4f1b2b0b82215d6c15b0f00a0aa",
 		"THIRDccc84c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a0ba",
 		"GENESISccc87d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a0ba"}
+	MockGenesisToken = "TOKENccc8" +
+		"7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a0ba"
+	MockReadToken    = "READtokenccc8d659a2fe"
+	MockRevivalToken = "REVIVALtokenc8d659a2f"
+	MockLockedClient = "LOCKEDc8" +
+		"84c7659a2feaa0c55ad015a3bf4f1b2b0b822cd1a5d6c15b0f00a0"
 	InvalidMockHashes = [3]string{
 		"INVALIDc884c7659a2feaa0c55ad015a3bf4f1b2b0b822cd1a5d6c15b0f00a09",
 		"ffa88d265a882b0716c60227e9ddb5c6a09542ec9b40b875463908a760ed0d6f",
@@ -118,8 +142,8 @@ func (mc *MockController) Init() error {
 }
 
 // Mock method implementation
-func (mc *MockController) InsertPost(post tp.Post) error {
-	return nil
+func (mc *MockController) InsertPost(post tp.Post) (int, error) {
+	return MockPost.Id, nil
 }
 
 // Mock method implementation
@@ -139,33 +163,283 @@ func (mc *MockController) SelectPostReactions(
 }
 
 // Mock method implementation
-func (mc *MockController) InsertHash(hash string) error {
+func (mc *MockController) InsertHash(hash string,
+	branch, postId int, postExpires, reactExpires time.Time) error {
 	return nil
 }
 
+// Mock method implementation, expiries are in the past when *MockIsExpired*
+func (mc *MockController) SelectPasscode(hash string) (tp.Passcode, error) {
+	id, err := mc.SelectPasscodeId(hash)
+	if err != nil {
+		return tp.Passcode{}, err
+	}
+	expires := time.Now().Add(time.Hour * 24)
+	if MockIsExpired {
+		expires = time.Now().Add(-time.Hour * 24)
+	}
+	return tp.Passcode{
+		Id:           id,
+		Hash:         hash,
+		Issued:       generateMockTime(),
+		PostExpires:  expires,
+		ReactExpires: expires,
+	}, nil
+}
+
+// Mock method implementation, all mock passcodes are on the first branch
+func (mc *MockController) SelectPasscodeBranch(hash string) (int, error) {
+	if _, err := mc.SelectPasscodeId(hash); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
 // Mock method implementation
-func (mc *MockController) SelectLatestTimestamp() (time.Time, error) {
-	return generateMockTime(), nil
+func (mc *MockController) SelectNextBranch() (int, error) {
+	return 1, nil
+}
+
+// Mock method implementation
+func (mc *MockController) SelectBranchHeadId(branch int) (int, error) {
+	return MockPost.Id, nil
+}
+
+// Mock method implementation
+func (mc *MockController) ConsumeHash(hash string) error {
+	return nil
+}
+
+// Mock method implementation
+func (mc *MockController) InsertHashNote(hash, note string) error {
+	return nil
+}
+
+// Mock method implementation
+func (mc *MockController) RetireExpiredHashes(now time.Time) (int64, error) {
+	return 0, nil
+}
+
+// Mock method implementation
+func (mc *MockController) InsertGenesisToken(hash string) error {
+	return nil
+}
+
+// Mock method implementation, only the mock genesis token is unused
+func (mc *MockController) SelectGenesisTokenUnused(hash string) (bool, error) {
+	return hash == MockGenesisToken, nil
+}
+
+// Mock method implementation, only the mock genesis token is unused
+func (mc *MockController) InsertGenesisPost(
+	post tp.Post, tokenHash string) (int, error) {
+	if tokenHash != MockGenesisToken {
+		return 0, nil
+	}
+	return MockPost.Id, nil
+}
+
+// Mock method implementation
+func (mc *MockController) InsertRevivalToken(hash string) error {
+	return nil
+}
+
+// Mock method implementation, only the mock revival token is unused
+func (mc *MockController) SelectRevivalTokenUnused(hash string) (bool, error) {
+	hashBytes := sha256.Sum256([]byte(MockRevivalToken))
+	return hash == hex.EncodeToString(hashBytes[:]), nil
+}
+
+// Mock method implementation, only the mock revival token is unused
+func (mc *MockController) InsertRevivalPost(
+	post tp.Post, tokenHash string) (int, error) {
+	if isUnused, _ := mc.SelectRevivalTokenUnused(tokenHash); !isUnused {
+		return 0, nil
+	}
+	return MockPost.Id, nil
+}
+
+// Mock method implementation
+func (mc *MockController) InsertReadToken(hash string) (int, error) {
+	return 1, nil
+}
+
+// Mock method implementation, only the mock read token is active
+func (mc *MockController) SelectReadTokenActive(hash string) (bool, error) {
+	hashBytes := sha256.Sum256([]byte(MockReadToken))
+	return hash == hex.EncodeToString(hashBytes[:]), nil
+}
+
+// Mock method implementation, only the mock read token can be revoked
+func (mc *MockController) RevokeReadToken(id int) (bool, error) {
+	return id == 1, nil
+}
+
+// Mock method implementation
+func (mc *MockController) InsertForfeit(passcodeId, postId int) error {
+	return nil
+}
+
+// Mock method implementation
+func (mc *MockController) SelectHeadForfeited(branch int) (bool, error) {
+	return MockIsForfeited, nil
+}
+
+// Mock method implementation
+func (mc *MockController) SelectForfeits() (map[int]time.Time, error) {
+	return map[int]time.Time{}, nil
 }
 
 // Mock method implementation
-func (mc *MockController) SelectCandidateHashes() ([5]string, error) {
-	return MockHashes, nil
+func (mc *MockController) InsertFork(fork tp.Fork,
+	hash string, postExpires, reactExpires time.Time) (int, error) {
+	return 1, nil
 }
 
 // Mock method implementation
+func (mc *MockController) SelectForks() ([]tp.Fork, error) {
+	return []tp.Fork{}, nil
+}
+
+// Mock method implementation, the mock posts are on the first branch
+func (mc *MockController) SelectPostBranch(postId int) (int, error) {
+	if postId == MockPost.Id || postId == MockSealedPostId {
+		return 0, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+// Mock method implementation, the mock posts use the default list
+func (mc *MockController) SelectPostWordList(postId int) (string, error) {
+	if _, err := mc.SelectPostBranch(postId); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+// Mock method implementation, there are no mock forks
+func (mc *MockController) SelectForkWordList(branch int) (string, error) {
+	return "", sql.ErrNoRows
+}
+
+// Mock method implementation, ids count up from genesis at 1
+func (mc *MockController) SelectPasscodeId(hash string) (int, error) {
+	for ind, value := range MockHashes {
+		if value == hash {
+			return len(MockHashes) - ind, nil
+		}
+	}
+	return 0, sql.ErrNoRows
+}
+
+// Mock method implementation
+func (mc *MockController) SelectPasscodeHash(id int) (string, error) {
+	if id < 1 || id > len(MockHashes) {
+		return "", sql.ErrNoRows
+	}
+	return MockHashes[len(MockHashes)-id], nil
+}
+
+// Mock method implementation
+func (mc *MockController) RotateHash(passcodeId int, hash, clientHash string,
+	since time.Time, limit int) (bool, error) {
+	return limit > 0, nil
+}
+
+// Mock method implementation
+func (mc *MockController) SelectRotationCount(
+	passcodeId int, since time.Time) (int, error) {
+	return 0, nil
+}
+
+// Mock method implementation
+func (mc *MockController) SelectRotations(
+	limit int) ([]tp.PasscodeRotation, error) {
+	return []tp.PasscodeRotation{}, nil
+}
+
+// Mock method implementation
+func (mc *MockController) SelectLatestTimestamp(
+	branch int) (time.Time, error) {
+	return generateMockTime(), nil
+}
+
+// Mock method implementation, the mock hashes fill as much of the window as
+// they can with genesis always last
+func (mc *MockController) SelectCandidateHashes(
+	branch, n int) ([]string, error) {
+	hashes := make([]string, n)
+	copy(hashes[:n-1], MockHashes[:len(MockHashes)-1])
+	hashes[n-1] = MockHashes[len(MockHashes)-1]
+	return hashes, nil
+}
+
+// Mock method implementation, where the crowded post has more attributed
+// reactions than the candidate window
 func (mc *MockController) SelectPostReactionHashes(
-	postId int) ([5]string, error) {
-	return [5]string{MockHashes[1], MockHashes[3], "", "", ""}, nil
+	postId int) ([]string, error) {
+	if postId != MockCrowdedPostId {
+		return []string{MockHashes[1], MockHashes[3]}, nil
+	}
+	var hashes []string
+	for i := 0; i < 6; i++ {
+		hashes = append(hashes, fmt.Sprintf("%064d", i))
+	}
+	return append(hashes, MockHashes[2]), nil
+}
+
+// Mock method implementation, consistent with the reaction hashes above
+func (mc *MockController) SelectReactedPostIds(hash string) ([]int, error) {
+	if hash == MockHashes[1] || hash == MockHashes[3] {
+		return []int{MockPost.Id}, nil
+	}
+	return []int{}, nil
 }
 
 // Mock method implementation
-func (mc *MockController) SelectDescriptors(postId int) (string, error) {
-	if postId == 1 {
-		return MockPost.Descriptors, nil
-	} else {
-		return "", nil
+func (mc *MockController) SelectPostReveal(postId int) (time.Time, error) {
+	if postId == MockSealedPostId {
+		return time.Now().Add(time.Hour * 24), nil
+	}
+	return time.Time{}, nil
+}
+
+// Mock method implementation, the mock post was authorised by the previous
+// holder and the sealed post by no passcode
+func (mc *MockController) SelectPostPasscodeId(postId int) (int, error) {
+	switch postId {
+	case MockPost.Id:
+		return 4, nil
+	case MockSealedPostId:
+		return 0, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+// Mock method implementation
+func (mc *MockController) ErasePost(
+	postId int, title, contents string, dropReactions bool) error {
+	return nil
+}
+
+// Mock method implementation, only the mock post has descriptors
+func (mc *MockController) SelectRecentDescriptors(
+	limit int) ([]string, error) {
+	return strings.Split(MockPost.Descriptors, ";"), nil
+}
+
+// Mock method implementation, only the mock post has descriptors
+func (mc *MockController) SelectPostDescriptors(
+	postId int) ([]tp.Descriptor, error) {
+	var descriptors []tp.Descriptor
+	if postId != MockPost.Id {
+		return descriptors, nil
+	}
+	for i, word := range strings.Split(MockPost.Descriptors, ";") {
+		descriptors = append(descriptors, tp.Descriptor{
+			Id: i + 1, PostId: postId, Position: i, Word: word})
 	}
+	return descriptors, nil
 }
 
 // Mock method implementation
@@ -177,6 +451,29 @@ func (mc *MockController) SelectAnonReactionCount(postId int) (int, error) {
 	}
 }
 
+// Mock method implementation
+func (mc *MockController) InsertSecurityEvent(event tp.SecurityEvent) error {
+	return nil
+}
+
+// Mock method implementation
+func (mc *MockController) SelectSecurityEvents(
+	limit int) ([]tp.SecurityEvent, error) {
+	return []tp.SecurityEvent{}, nil
+}
+
+// Mock method implementation, the locked client has failed 10 times recently
+func (mc *MockController) SelectFailureTimes(
+	clientHash string, since time.Time) ([]time.Time, error) {
+	var times []time.Time
+	if clientHash == MockLockedClient {
+		for i := 0; i < 10; i++ {
+			times = append(times, time.Now().Add(-time.Minute))
+		}
+	}
+	return times, nil
+}
+
 // Mock method implementation
 func (mc *MockController) Clear() bool { return true }
 
@@ -3,10 +3,11 @@ package utils
 import (
 	"bytes"
 	"crypto/rand"
+	"fmt"
 	"math/big"
+	"os"
 	"sort"
 	"strconv"
-	"strings"
 	"time"
 
 	tp "github.com/georgejmx/whisper-blog/types"
@@ -15,6 +16,22 @@ import (
 const (
 	HOURS_INT = 3600  // 1 hour in seconds
 	DAYS_INT  = 86400 // 1 day in seconds
+
+	LOCKOUT_BASE = time.Minute    // lockout after reaching the threshold
+	LOCKOUT_MAX  = time.Hour * 24 // longest possible lockout
+
+	// Shown in place of the contents of a post until it is revealed
+	SEALED_PLACEHOLDER = "This whisper is sealed until its reveal"
+
+	DEFAULT_WINDOW = 5  // candidates when the window is not configured
+	FINAL_TIER     = 10 // days until every candidate can post
+
+	// Replaces the title and contents of a post erased by its author
+	ERASED_TITLE       = "erased whisper"
+	ERASED_PLACEHOLDER = "This whisper was erased by its author"
+
+	// Provenance of a post that revived a dormant chain
+	REVIVED_PROVENANCE = "revived after dormancy"
 )
 
 /* Generates a new plain-text to lead the chain, that is moderately secure
@@ -46,51 +63,126 @@ func TimeSincePost(isDays bool, lastPostTime time.Time) int {
 func ValidateInputLength(p tp.Post) bool {
 	if len(p.Title) > 40 || len(p.Author) > 10 || len(p.Contents) > 1500 {
 		return false
+	} else if len(p.Note) > 500 {
+		return false
 	}
 	return true
 }
 
 /* Validates if the provided hash index has the authority to make a post at
-this time */
-func ValidateHashTiming(lastPostTime time.Time, hashIndex int) bool {
+this time. When the current holder has forfeited, every window is treated as
+having expired */
+func ValidateHashTiming(
+	lastPostTime time.Time, hashIndex int, isForfeited bool) bool {
 	daysElapsed := TimeSincePost(true, lastPostTime)
+	if isForfeited {
+		daysElapsed = FINAL_TIER
+	}
+	return daysElapsed >= TierOpensAfter(hashIndex)
+}
+
+/* Gets the whole days after the head post from which the candidate at
+*hashIndex* can post. This is the policy of Chain Law */
+func TierOpensAfter(hashIndex int) int {
+	switch {
+	// the genesis holder only joins the final tier, however short the window
+	case hashIndex > 0 && hashIndex >= GenesisIndex():
+		return FINAL_TIER
 
 	// the next person can exclusively make a post for 5 days
-	if hashIndex <= 0 && daysElapsed < 5 {
-		return true
-	}
+	case hashIndex <= 0:
+		return 0
 
 	// the previous person can also make a post within a week
-	if hashIndex <= 1 && daysElapsed >= 5 {
-		return true
-	}
+	case hashIndex == 1:
+		return 5
 
 	// the previous two people can also make a post within 9 days
-	if hashIndex <= 2 && daysElapsed >= 7 {
-		return true
-	}
+	case hashIndex == 2:
+		return 7
 
 	// the previous 3 people can make a post within 10 days
-	if hashIndex <= 3 && daysElapsed >= 9 {
-		return true
+	case hashIndex == 3:
+		return 9
 	}
 
 	// all candidate hashes, including the genesis hash, can make a post
 	// after 10 days have elapsed
-	if daysElapsed >= 10 {
-		return true
+	return FINAL_TIER
+}
+
+/* Gets each tier of the candidate window under Chain Law, from the current
+holder to genesis, with when it can post after the head post at *headTime*.
+A forfeit opens every tier from the head post */
+func ChainLaw(headTime time.Time, isForfeited bool) []tp.LawTier {
+	tiers := make([]tp.LawTier, CandidateWindow())
+	for ind := range tiers {
+		opensAt := headTime.Add(
+			time.Duration(TierOpensAfter(ind)) * time.Second * DAYS_INT)
+		if isForfeited {
+			opensAt = headTime
+		}
+		tiers[ind] = tp.LawTier{
+			Tier:      GetTierName(ind),
+			HashIndex: ind,
+			Role:      CandidateRole(ind, len(tiers)),
+			OpensAt:   opensAt,
+			Eligible:  ValidateHashTiming(headTime, ind, isForfeited),
+		}
+	}
+	return tiers
+}
+
+/* Gets the name of the tier of the candidate at *hashIndex* */
+func GetTierName(hashIndex int) string {
+	switch {
+	case hashIndex == 0:
+		return "current holder"
+	case hashIndex == GenesisIndex():
+		return "genesis"
+	case hashIndex == 1:
+		return "previous holder"
 	}
+	return fmt.Sprintf("holder %d back", hashIndex)
+}
+
+/* Gets the role of the candidate at *index* of a window of *length* */
+func CandidateRole(index, length int) string {
+	if index == 0 {
+		return tp.ROLE_HEAD
+	} else if index == length-1 {
+		return tp.ROLE_GENESIS
+	}
+	return tp.ROLE_RECENT
+}
 
-	return false
+/* Gets how long a client is locked out after *failures* failed attempts. There
+is no lockout below *threshold*, then the lockout doubles with each further
+failure up to a maximum of one day */
+func LockoutDuration(failures, threshold int) time.Duration {
+	if threshold <= 0 || failures < threshold {
+		return 0
+	}
+
+	lockout := LOCKOUT_BASE
+	for i := threshold; i < failures && lockout < LOCKOUT_MAX; i++ {
+		lockout *= 2
+	}
+	if lockout > LOCKOUT_MAX {
+		return LOCKOUT_MAX
+	}
+	return lockout
 }
 
-/* Checks if the descriptor is in the descriptors string */
-func CheckDescriptor(descriptor, descriptors string) bool {
-	if strings.Contains(descriptors, descriptor) &&
-		!strings.Contains(descriptor, ";") {
-		return true
+/* Finds the descriptor of a post that is exactly the word */
+func FindDescriptor(
+	word string, descriptors []tp.Descriptor) (tp.Descriptor, bool) {
+	for _, descriptor := range descriptors {
+		if descriptor.Word == word {
+			return descriptor, true
+		}
 	}
-	return false
+	return tp.Descriptor{}, false
 }
 
 /* Gets the tailwind CSS colour of a tag */
@@ -158,6 +250,186 @@ func AwardDescriptors(reactions []tp.Reaction) []tp.Reaction {
 	return reactions
 }
 
+/* Determines whether a post with the provided reveal time is still sealed.
+Posts without a reveal time were never sealed */
+func IsSealed(reveal *time.Time) bool {
+	return reveal != nil && time.Now().Before(*reveal)
+}
+
+/* Hides everything but the title and timing of a sealed post, so that it can
+be shown as a link in the chain */
+func SealPost(post tp.Post) tp.Post {
+	return tp.Post{
+		Id:         post.Id,
+		Title:      post.Title,
+		Contents:   SEALED_PLACEHOLDER,
+		Tag:        post.Tag,
+		Time:       post.Time,
+		Reveal:     post.Reveal,
+		Sealed:     true,
+		PasscodeId: post.PasscodeId,
+		HashIndex:  post.HashIndex,
+		Role:       post.Role,
+		Provenance: post.Provenance,
+		Forfeited:  post.Forfeited,
+		Branch:     post.Branch,
+		ParentId:   post.ParentId,
+		Revival:    post.Revival,
+	}
+}
+
+/* Arranges posts into a tree of branches, oldest first. Posts without a parent
+in the provided posts, such as the genesis post, are the roots */
+func BuildTree(posts []tp.Post) []tp.PostNode {
+	ordered := make([]tp.Post, len(posts))
+	copy(ordered, posts)
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].Id < ordered[j].Id
+	})
+
+	// Grouping each post under its parent
+	isPresent := make(map[int]bool)
+	for _, post := range ordered {
+		isPresent[post.Id] = true
+	}
+	children := make(map[int][]tp.Post)
+	for _, post := range ordered {
+		parentId := post.ParentId
+		if !isPresent[parentId] {
+			parentId = 0
+		}
+		children[parentId] = append(children[parentId], post)
+	}
+	return buildNodes(children, 0)
+}
+
+/* Determines which chain each post belongs to, that of the fork branch nearest
+above it or otherwise the original chain 0. Each post also lists the forks
+taken from it, and each fork the chain its source post is on */
+func AssignChains(
+	posts []tp.Post, forks []tp.Fork) ([]tp.Post, []tp.Fork) {
+	forkOfBranch := make(map[int]int)
+	forksOfPost := make(map[int][]int)
+	for _, fork := range forks {
+		forkOfBranch[fork.Branch] = fork.Id
+		forksOfPost[fork.SourcePostId] = append(
+			forksOfPost[fork.SourcePostId], fork.Id)
+	}
+	parents := make(map[int]int)
+	branches := make(map[int]int)
+	for _, post := range posts {
+		parents[post.Id] = post.ParentId
+		branches[post.Id] = post.Branch
+	}
+
+	// Walking up from each post until a fork branch or the root is reached
+	chainOf := func(postId int) int {
+		for postId != 0 {
+			if forkId, ok := forkOfBranch[branches[postId]]; ok {
+				return forkId
+			} else if _, ok := branches[parents[postId]]; !ok {
+				return 0
+			}
+			postId = parents[postId]
+		}
+		return 0
+	}
+
+	chained := make([]tp.Post, len(posts))
+	for i, post := range posts {
+		post.Chain = chainOf(post.Id)
+		post.Forks = forksOfPost[post.Id]
+		chained[i] = post
+	}
+	linked := make([]tp.Fork, len(forks))
+	for i, fork := range forks {
+		if _, ok := branches[fork.SourcePostId]; ok {
+			fork.SourceChain = chainOf(fork.SourcePostId)
+		}
+		linked[i] = fork
+	}
+	return chained, linked
+}
+
+/* Gets only the posts that belong to the provided chain */
+func FilterChain(posts []tp.Post, chain int) []tp.Post {
+	filtered := []tp.Post{}
+	for _, post := range posts {
+		if post.Chain == chain {
+			filtered = append(filtered, post)
+		}
+	}
+	return filtered
+}
+
+/* Builds the nodes of the posts under *parentId*, along with their children */
+func buildNodes(children map[int][]tp.Post, parentId int) []tp.PostNode {
+	nodes := make([]tp.PostNode, len(children[parentId]))
+	for i, post := range children[parentId] {
+		nodes[i] = tp.PostNode{
+			Post:     post,
+			Children: buildNodes(children, post.Id),
+		}
+	}
+	return nodes
+}
+
+/* Gets the number of candidates in the window that can post or react, from
+the head through recent holders to genesis. Falls back to the default when not
+configured, and is never less than the head and genesis */
+func CandidateWindow() int {
+	window, err := strconv.Atoi(os.Getenv("CANDIDATE_WINDOW"))
+	if err != nil {
+		return DEFAULT_WINDOW
+	} else if window < 2 {
+		return 2
+	}
+	return window
+}
+
+/* Gets the candidate index of the genesis holder, the last in the window */
+func GenesisIndex() int {
+	return CandidateWindow() - 1
+}
+
+/* Gets the provenance of a post from the index and role of the candidate
+that made it, for showing as a badge. The role is recorded with the post, so
+changing the candidate window does not change the badge. Empty for posts with
+no recorded role */
+func GetProvenance(hashIndex int, role string) string {
+	switch {
+	case role == tp.ROLE_HEAD:
+		return "passed on"
+	case role == tp.ROLE_RECENT && hashIndex == 1:
+		return "rescued by previous holder"
+	case role == tp.ROLE_RECENT:
+		return fmt.Sprintf("rescued by holder %d back", hashIndex)
+	case role == tp.ROLE_GENESIS:
+		return "revived by genesis"
+	}
+	return ""
+}
+
+/* Counts how the posts of the chain were made, from the recorded role of the
+candidate that made each. Posts with no recorded role are not counted */
+func GetChainStats(posts []tp.Post) tp.ChainStats {
+	var stats tp.ChainStats
+	for _, post := range posts {
+		switch post.Role {
+		case tp.ROLE_HEAD:
+			stats.PassedOn++
+		case tp.ROLE_RECENT:
+			stats.Rescued++
+		case tp.ROLE_GENESIS:
+			stats.Revived++
+		default:
+			continue
+		}
+		stats.Links++
+	}
+	return stats
+}
+
 /* Gets a UI suitable time for the post */
 func GetTimestring(moment time.Time) string {
 	rfc := moment.Format(time.RFC1123)
@@ -1,20 +1,102 @@
 package utils
 
-import "testing"
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	tp "github.com/georgejmx/whisper-blog/types"
+)
 
 /* Tests the validate hash function, with edge cases around mock latest time */
 func TestValidateHashTiming(t *testing.T) {
 	t.Log(generateMockTime())
 
-	if outcome := ValidateHashTiming(generateMockTime(), 2); !outcome {
+	if outcome := ValidateHashTiming(generateMockTime(), 2, false); !outcome {
 		t.Log("penultimate previous post index failed!?!")
 		t.Fail()
 	}
 
-	if outcome := ValidateHashTiming(generateMockTime(), 3); outcome {
+	if outcome := ValidateHashTiming(generateMockTime(), 3, false); outcome {
 		t.Log("third previous post index succeeded!?!")
 		t.Fail()
 	}
+
+	// A forfeit opens every window, even straight after a post
+	if outcome := ValidateHashTiming(time.Now(), 4, true); !outcome {
+		t.Log("genesis index failed after forfeit")
+		t.Fail()
+	}
+
+	// A shorter window leaves the genesis holder waiting for the final tier
+	os.Setenv("CANDIDATE_WINDOW", "3")
+	defer os.Unsetenv("CANDIDATE_WINDOW")
+	if outcome := ValidateHashTiming(
+		time.Now().AddDate(0, 0, -8), 2, false); outcome {
+		t.Log("genesis index of a short window succeeded early")
+		t.Fail()
+	}
+	if outcome := ValidateHashTiming(
+		time.Now().AddDate(0, 0, -8), 1, false); !outcome {
+		t.Log("recent index of a short window failed")
+		t.Fail()
+	}
+}
+
+/* Tests that Chain Law always agrees with the timing validation, for every
+tier and whether or not the head has been forfeited */
+func TestChainLaw(t *testing.T) {
+	headTime := time.Now().AddDate(0, 0, -8)
+	for _, isForfeited := range []bool{false, true} {
+		tiers := ChainLaw(headTime, isForfeited)
+		if len(tiers) != DEFAULT_WINDOW || tiers[0].Role != tp.ROLE_HEAD ||
+			tiers[GenesisIndex()].Tier != "genesis" {
+			t.Fatalf("unexpected tiers: %+v", tiers)
+		}
+		for _, tier := range tiers {
+			isValTime := ValidateHashTiming(
+				headTime, tier.HashIndex, isForfeited)
+			if tier.Eligible != isValTime ||
+				tier.Eligible != !tier.OpensAt.After(time.Now()) {
+				t.Logf("tier disagrees with timing: %+v", tier)
+				t.Fail()
+			}
+		}
+	}
+}
+
+/* Tests that the Chain Law calendar has an event for each tier yet to open,
+with lines folded and text escaped */
+func TestChainLawCalendar(t *testing.T) {
+	headTime := time.Now().AddDate(0, 0, -6)
+	ics := ChainLawCalendar(12, headTime, ChainLaw(headTime, false))
+	if !strings.HasPrefix(ics, "BEGIN:VCALENDAR\r\n") ||
+		!strings.HasSuffix(ics, "END:VCALENDAR\r\n") {
+		t.Fatalf("malformed calendar: %q", ics)
+	}
+
+	// Only the previous holder's tier has opened after 6 days
+	if events := strings.Count(ics, "BEGIN:VEVENT"); events != 3 {
+		t.Logf("expected 3 upcoming events, found %d", events)
+		t.Fail()
+	}
+	for _, line := range strings.Split(ics, "\r\n") {
+		if len(line) > ICS_FOLD {
+			t.Logf("expected lines to be folded: %q", line)
+			t.Fail()
+		}
+	}
+	if !strings.Contains(ics, `Chain Law\, branch 12`) {
+		t.Log("expected commas to be escaped")
+		t.Fail()
+	}
+
+	ics = ChainLawCalendar(0, headTime, ChainLaw(headTime, true))
+	if strings.Contains(ics, "BEGIN:VEVENT") {
+		t.Log("expected no events once the head has forfeited")
+		t.Fail()
+	}
 }
 
 /* Tests that a correct passcode is generated, that are not easily recreated
@@ -35,3 +117,184 @@ func TestGenerateRawPasscode(t *testing.T) {
 		i++
 	}
 }
+
+/* Tests that lockouts only begin at the threshold, then grow progressively up
+to the maximum */
+func TestLockoutDuration(t *testing.T) {
+	if lockout := LockoutDuration(4, 5); lockout != 0 {
+		t.Logf("expected no lockout below threshold, found %v", lockout)
+		t.Fail()
+	}
+
+	if lockout := LockoutDuration(5, 5); lockout != LOCKOUT_BASE {
+		t.Logf("expected base lockout at threshold, found %v", lockout)
+		t.Fail()
+	}
+
+	if lockout := LockoutDuration(8, 5); lockout != LOCKOUT_BASE*8 {
+		t.Logf("expected lockout to double per failure, found %v", lockout)
+		t.Fail()
+	}
+
+	if lockout := LockoutDuration(500, 5); lockout != LOCKOUT_MAX {
+		t.Logf("expected lockout to be capped, found %v", lockout)
+		t.Fail()
+	}
+
+	if lockout := LockoutDuration(500, 0); lockout != 0 {
+		t.Logf("expected no lockout when threshold unset, found %v", lockout)
+		t.Fail()
+	}
+}
+
+/* Tests that a descriptor is only found when it is exactly the word, never
+part of it */
+func TestFindDescriptor(t *testing.T) {
+	descriptors := []tp.Descriptor{
+		{Id: 4, PostId: 1, Position: 0, Word: "unripe"},
+		{Id: 5, PostId: 1, Position: 1, Word: "ripe"},
+	}
+	if descriptor, ok := FindDescriptor("ripe", descriptors); !ok ||
+		descriptor.Id != 5 {
+		t.Logf("expected the exact descriptor, found %+v", descriptor)
+		t.Fail()
+	}
+	for _, word := range []string{"e", "rip", "unripe;ripe", "Ripe", ""} {
+		if _, ok := FindDescriptor(word, descriptors); ok {
+			t.Logf("expected %q not to be a descriptor", word)
+			t.Fail()
+		}
+	}
+}
+
+/* Tests that posts are only sealed before their reveal time, and that sealing
+keeps the title but hides the rest */
+func TestSealPost(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	if IsSealed(nil) || IsSealed(&past) || !IsSealed(&future) {
+		t.Log("expected only a future reveal time to be sealed")
+		t.Fail()
+	}
+
+	post := MockPost
+	post.Reveal = &future
+	sealed := SealPost(post)
+	if !sealed.Sealed || sealed.Title != post.Title ||
+		sealed.Contents != SEALED_PLACEHOLDER || sealed.Author != "" ||
+		sealed.Descriptors != "" || !sealed.Time.Equal(post.Time) {
+		t.Logf("unexpected sealed post: %+v", sealed)
+		t.Fail()
+	}
+}
+
+/* Tests that each candidate role has its own provenance, and that chain
+stats count how often the chain needed rescuing. Both come from the role
+recorded with the post, so changing the candidate window changes neither */
+func TestProvenanceAndStats(t *testing.T) {
+	window := os.Getenv("CANDIDATE_WINDOW")
+	defer os.Setenv("CANDIDATE_WINDOW", window)
+
+	type candidate struct {
+		hashIndex int
+		role      string
+	}
+	expected := map[candidate]string{{-1, ""}: "",
+		{0, tp.ROLE_HEAD}:    "passed on",
+		{1, tp.ROLE_RECENT}:  "rescued by previous holder",
+		{3, tp.ROLE_RECENT}:  "rescued by holder 3 back",
+		{4, tp.ROLE_GENESIS}: "revived by genesis"}
+	var posts []tp.Post
+	for _, role := range []string{tp.ROLE_HEAD, tp.ROLE_HEAD, tp.ROLE_RECENT,
+		tp.ROLE_RECENT, tp.ROLE_GENESIS, tp.ROLE_HEAD, ""} {
+		posts = append(posts, tp.Post{Role: role})
+	}
+
+	for _, size := range []string{"5", "8"} {
+		os.Setenv("CANDIDATE_WINDOW", size)
+		for cand, provenance := range expected {
+			found := GetProvenance(cand.hashIndex, cand.role)
+			if found != provenance {
+				t.Logf("expected %q for %+v in a window of %s, found %q",
+					provenance, cand, size, found)
+				t.Fail()
+			}
+		}
+		stats := GetChainStats(posts)
+		if stats != (tp.ChainStats{
+			Links: 6, PassedOn: 3, Rescued: 2, Revived: 1}) {
+			t.Logf("unexpected chain stats in a window of %s: %+v", size, stats)
+			t.Fail()
+		}
+	}
+}
+
+/* Tests that posts are arranged under their parents, oldest first */
+func TestBuildTree(t *testing.T) {
+	posts := []tp.Post{
+		{Id: 5, ParentId: 3, Branch: 1},
+		{Id: 4, ParentId: 2},
+		{Id: 3, ParentId: 2},
+		{Id: 2, ParentId: 1},
+		{Id: 1},
+	}
+	tree := BuildTree(posts)
+	if len(tree) != 1 || tree[0].Post.Id != 1 {
+		t.Fatalf("expected the genesis post as the only root: %+v", tree)
+	}
+	branching := tree[0].Children[0]
+	if branching.Post.Id != 2 || len(branching.Children) != 2 {
+		t.Fatalf("expected post 2 to branch twice: %+v", branching)
+	}
+	first, second := branching.Children[0], branching.Children[1]
+	if first.Post.Id != 3 || second.Post.Id != 4 ||
+		len(first.Children) != 1 || first.Children[0].Post.Id != 5 {
+		t.Logf("unexpected branches: %+v, %+v", first, second)
+		t.Fail()
+	}
+
+	// Posts whose parent is missing, such as from a partial chain, are roots
+	if roots := BuildTree(posts[:2]); len(roots) != 2 {
+		t.Logf("expected 2 roots, found %d", len(roots))
+		t.Fail()
+	}
+}
+
+/* Tests that posts take the chain of the fork nearest above them, and forks
+the chain of their source post */
+func TestAssignChains(t *testing.T) {
+	posts := []tp.Post{
+		{Id: 6, ParentId: 5, Branch: 3},
+		{Id: 5, ParentId: 4, Branch: 2},
+		{Id: 4, ParentId: 2, Branch: 1},
+		{Id: 3, ParentId: 2},
+		{Id: 2, ParentId: 1},
+		{Id: 1},
+	}
+	forks := []tp.Fork{
+		{Id: 1, SourcePostId: 2, Branch: 1},
+		{Id: 2, SourcePostId: 4, Branch: 2},
+	}
+	chained, linked := AssignChains(posts, forks)
+
+	// Branch 3 is a branch within the second fork, not a fork of its own
+	expected := []int{2, 2, 1, 0, 0, 0}
+	for i, post := range chained {
+		if post.Chain != expected[i] {
+			t.Logf("expected post %d on chain %d, found %d", post.Id,
+				expected[i], post.Chain)
+			t.Fail()
+		}
+	}
+	if len(chained[4].Forks) != 1 || chained[4].Forks[0] != 1 {
+		t.Logf("expected post 2 to list its fork: %v", chained[4].Forks)
+		t.Fail()
+	}
+	if linked[0].SourceChain != 0 || linked[1].SourceChain != 1 {
+		t.Logf("unexpected source chains: %+v", linked)
+		t.Fail()
+	}
+	if filtered := FilterChain(chained, 2); len(filtered) != 2 {
+		t.Logf("expected 2 posts on the second fork, found %d", len(filtered))
+		t.Fail()
+	}
+}
@@ -0,0 +1,283 @@
+package words
+
+import (
+	_ "embed"
+	"errors"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Returned when a list has too few allowed words to describe a post
+var ErrTooFewWords = errors.New("word list has too few allowed words")
+
+//go:embed filters/blocklist.txt
+var blocklist []byte
+
+// Words that descriptors can and cannot be, compared ignoring case. When the
+// allowlist is empty every word not blocked is allowed
+type Filter struct {
+	allow map[string]bool
+	block map[string]bool
+}
+
+// Picks descriptors from word lists with its own source of randomness, so
+// that a seeded generator always picks the same words
+type Generator struct {
+	mu      sync.Mutex
+	rng     *rand.Rand
+	filter  Filter
+	lexicon *Lexicon
+}
+
+var defaultGenerator = NewGenerator(time.Now().UnixNano(), DefaultFilter())
+
+/* Creates a filter that allows only the allowed words, when there are any,
+and never the blocked words */
+func NewFilter(allowed, blocked []string) Filter {
+	filter := Filter{allow: map[string]bool{}, block: map[string]bool{}}
+	for _, word := range allowed {
+		filter.allow[strings.ToLower(word)] = true
+	}
+	for _, word := range blocked {
+		filter.block[strings.ToLower(word)] = true
+	}
+	return filter
+}
+
+/* Creates the filter that blocks the embedded blocklist */
+func DefaultFilter() Filter {
+	filter, _ := LoadFilter("", "")
+	return filter
+}
+
+/* Loads a filter of the embedded blocklist along with the words in the
+blocklist file, and only the words in the allowlist file when there is one.
+Files have one word on each line, the same as a word list. Empty paths are
+skipped */
+func LoadFilter(allowPath, blockPath string) (Filter, error) {
+	list, _ := ParseList("blocklist", blocklist)
+	blocked := listWords(list)
+	allowed, err := readWords(allowPath)
+	if err != nil {
+		return Filter{}, err
+	}
+	extra, err := readWords(blockPath)
+	if err != nil {
+		return Filter{}, err
+	}
+	return NewFilter(allowed, append(blocked, extra...)), nil
+}
+
+/* Determines whether the filter allows the word */
+func (f Filter) Allows(word string) bool {
+	word = strings.ToLower(word)
+	if f.block[word] {
+		return false
+	}
+	return len(f.allow) == 0 || f.allow[word]
+}
+
+/* Sets the filter used when generating descriptors for posts */
+func SetFilter(filter Filter) {
+	defaultGenerator.mu.Lock()
+	defer defaultGenerator.mu.Unlock()
+	defaultGenerator.filter = filter
+}
+
+/* Creates a generator whose source is seeded with *seed*, scoring words with
+the embedded lexicon */
+func NewGenerator(seed int64, filter Filter) *Generator {
+	return &Generator{rng: rand.New(rand.NewSource(seed)), filter: filter,
+		lexicon: DefaultLexicon()}
+}
+
+/* Picks DESCRIPTOR_SIZE different words from the list that the filter
+allows, avoiding the recently used words. Recent words are only used again
+when there are too few others */
+func (g *Generator) Descriptors(
+	list WordList, recent []string) ([]string, error) {
+	fresh, reused := g.eligible(list, avoidedWords(recent))
+	if len(fresh)+len(reused) < DESCRIPTOR_SIZE {
+		return nil, ErrTooFewWords
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	descriptors := g.pick(fresh, DESCRIPTOR_SIZE)
+	if missing := DESCRIPTOR_SIZE - len(descriptors); missing > 0 {
+		descriptors = append(descriptors, g.pick(reused, missing)...)
+	}
+	return descriptors, nil
+}
+
+/* Picks descriptors like Descriptors, but scores the words against the text
+of the post first. Up to RELEVANT_SIZE of the most relevant words are picked,
+and the rest at random for surprise, preferring words that do not oppose the
+post. Ties are broken by the generator's source, so a seeded generator always
+suggests the same words for the same post */
+func (g *Generator) Suggest(
+	list WordList, recent []string, text string) ([]string, error) {
+	fresh, reused := g.eligible(list, avoidedWords(recent))
+	if len(fresh)+len(reused) < DESCRIPTOR_SIZE {
+		return nil, ErrTooFewWords
+	}
+	relevance := g.lexicon.Relevance(text)
+	scores := make(map[string]int, len(fresh))
+	for _, word := range fresh {
+		scores[word] = relevance.Score(word)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.rng.Shuffle(len(fresh), func(i, j int) {
+		fresh[i], fresh[j] = fresh[j], fresh[i]
+	})
+	sort.SliceStable(fresh, func(i, j int) bool {
+		return scores[fresh[i]] > scores[fresh[j]]
+	})
+
+	// Taking the most relevant words, then filling up from the others
+	var descriptors, neutral, opposed []string
+	for _, word := range fresh {
+		switch score := scores[word]; {
+		case score > 0 && len(descriptors) < RELEVANT_SIZE:
+			descriptors = append(descriptors, word)
+		case score >= 0:
+			neutral = append(neutral, word)
+		default:
+			opposed = append(opposed, word)
+		}
+	}
+	for _, pool := range [][]string{neutral, opposed, reused} {
+		descriptors = append(descriptors,
+			g.pick(pool, DESCRIPTOR_SIZE-len(descriptors))...)
+	}
+
+	// Mixing the relevant words in with the random ones
+	return g.pick(descriptors, len(descriptors)), nil
+}
+
+/* Picks a single word from the list that the filter allows */
+func (g *Generator) Descriptor(list WordList) (string, error) {
+	fresh, _ := g.eligible(list, nil)
+	if len(fresh) == 0 {
+		return "", ErrTooFewWords
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.pick(fresh, 1)[0], nil
+}
+
+/* Gets each different word of the list that the filter allows, split into
+those not avoided and those that are */
+func (g *Generator) eligible(
+	list WordList, avoided map[string]bool) ([]string, []string) {
+	g.mu.Lock()
+	filter := g.filter
+	g.mu.Unlock()
+
+	var fresh, reused []string
+	seen := map[string]bool{}
+	for i := 0; i < list.Len(); i++ {
+		word := list.Word(i)
+		key := strings.ToLower(word)
+		if seen[key] || !filter.Allows(word) {
+			continue
+		}
+		seen[key] = true
+		if avoided[key] {
+			reused = append(reused, word)
+		} else {
+			fresh = append(fresh, word)
+		}
+	}
+	return fresh, reused
+}
+
+/* Picks up to *n* of the words at random, each at most once. Partially
+shuffles the words in place, so the caller must hold the lock */
+func (g *Generator) pick(words []string, n int) []string {
+	if n > len(words) {
+		n = len(words)
+	}
+	for i := 0; i < n; i++ {
+		j := i + g.rng.Intn(len(words)-i)
+		words[i], words[j] = words[j], words[i]
+	}
+	return append([]string{}, words[:n]...)
+}
+
+/* Generates a descriptors string of the format 'word;word;word' of length 10,
+to be bound to a post in the database. Words are picked from the list,
+avoiding the recently used words */
+func GenerateDescriptors(list WordList, recent []string) (string, error) {
+	descriptors, err := defaultGenerator.Descriptors(list, recent)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(descriptors, ";"), nil
+}
+
+/* Generates a descriptors string like GenerateDescriptors, with most of the
+words picked for their relevance to the text of the post */
+func SuggestDescriptors(
+	list WordList, recent []string, text string) (string, error) {
+	descriptors, err := defaultGenerator.Suggest(list, recent, text)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(descriptors, ";"), nil
+}
+
+/* Checks that the list has enough words that the filter allows to describe
+a post */
+func CheckList(list WordList) error {
+	fresh, _ := defaultGenerator.eligible(list, nil)
+	if len(fresh) < DESCRIPTOR_SIZE {
+		return ErrTooFewWords
+	}
+	return nil
+}
+
+/* Generates a single descriptor, picked at random from the list */
+func GenerateDescriptor(list WordList) (string, error) {
+	return defaultGenerator.Descriptor(list)
+}
+
+/* Gets the set of recently used words, ignoring case */
+func avoidedWords(recent []string) map[string]bool {
+	avoided := map[string]bool{}
+	for _, word := range recent {
+		avoided[strings.ToLower(word)] = true
+	}
+	return avoided
+}
+
+/* Reads the words of a file, or none when the path is empty */
+func readWords(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	list, err := ParseList(path, data)
+	if err != nil {
+		return nil, err
+	}
+	return listWords(list), nil
+}
+
+/* Gets every word of the list */
+func listWords(list WordList) []string {
+	words := make([]string, list.Len())
+	for i := range words {
+		words[i] = list.Word(i)
+	}
+	return words
+}
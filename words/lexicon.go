@@ -0,0 +1,119 @@
+package words
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	MODE_RANDOM     = "random"  // descriptors are picked at random
+	MODE_CONTENT    = "content" // descriptors are scored against the post
+	RELEVANT_SIZE   = 7         // descriptors picked for their relevance
+	MIN_CUE_PREFIX  = 4         // shortest cue that matches longer words
+	TOPIC_WEIGHT    = 2         // score of each cue found in the post
+	CONFLICT_WEIGHT = 2         // penalty of opposing the post's sentiment
+)
+
+//go:embed lexicon/lexicon.txt
+var lexiconData []byte
+
+// Sentiment polarity and topical cues of words, used to score descriptors
+// against the text of a post without any network access
+type Lexicon struct {
+	polarity map[string]int
+	cues     map[string][]string
+}
+
+// The words of a post and its sentiment, which candidate descriptors are
+// scored against
+type Relevance struct {
+	lexicon   *Lexicon
+	tokens    []string
+	sentiment int
+}
+
+var defaultLexicon = mustParseLexicon(lexiconData)
+
+/* Parses a lexicon with a word on each line, followed by its polarity from -2
+to 2 and then its cues. Blank lines and lines starting with # are skipped */
+func ParseLexicon(data []byte) (*Lexicon, error) {
+	lexicon := &Lexicon{polarity: map[string]int{}, cues: map[string][]string{}}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(strings.ToLower(scanner.Text()))
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("lexicon line %d: missing polarity", line)
+		}
+		polarity, err := strconv.Atoi(fields[1])
+		if err != nil || polarity < -2 || polarity > 2 {
+			return nil, fmt.Errorf("lexicon line %d: invalid polarity", line)
+		}
+		lexicon.polarity[fields[0]] = polarity
+		lexicon.cues[fields[0]] = fields[2:]
+	}
+	return lexicon, scanner.Err()
+}
+
+/* Gets the embedded lexicon */
+func DefaultLexicon() *Lexicon {
+	return defaultLexicon
+}
+
+/* Parses the embedded lexicon once, at startup */
+func mustParseLexicon(data []byte) *Lexicon {
+	lexicon, err := ParseLexicon(data)
+	if err != nil {
+		panic("error parsing embedded lexicon: " + err.Error())
+	}
+	return lexicon
+}
+
+/* Splits the text of a post into its lowercase words, and sums the polarity
+of those in the lexicon into its sentiment */
+func (l *Lexicon) Relevance(text string) Relevance {
+	relevance := Relevance{lexicon: l}
+	relevance.tokens = strings.FieldsFunc(strings.ToLower(text),
+		func(r rune) bool { return !unicode.IsLetter(r) && r != '\'' })
+	for _, token := range relevance.tokens {
+		relevance.sentiment += l.polarity[token]
+	}
+	return relevance
+}
+
+/* Scores a candidate descriptor against the post. Each of its cues found in
+the post, or the word itself, adds to the score, and agreeing with the
+sentiment of the post adds one while opposing it takes some away. A positive
+score is relevant to the post */
+func (r Relevance) Score(word string) int {
+	word = strings.ToLower(word)
+	polarity, ok := r.lexicon.polarity[word]
+	if !ok {
+		return 0
+	}
+
+	score := 0
+	for _, cue := range append([]string{word}, r.lexicon.cues[word]...) {
+		for _, token := range r.tokens {
+			if token == cue || (len(cue) >= MIN_CUE_PREFIX &&
+				strings.HasPrefix(token, cue)) {
+				score += TOPIC_WEIGHT
+				break
+			}
+		}
+	}
+	switch {
+	case polarity*r.sentiment > 0:
+		score++
+	case polarity*r.sentiment < 0:
+		score -= CONFLICT_WEIGHT
+	}
+	return score
+}
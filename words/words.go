@@ -3,54 +3,140 @@ package words
 import (
 	"bufio"
 	"bytes"
-	_ "embed"
-	"math/rand"
+	"embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
-	"time"
+	"sync"
 )
 
-const WORD_COUNT = 1350 // number of words in *adjectives.txt*
+const (
+	DEFAULT_LIST    = "adjectives" // used when no list is configured
+	DESCRIPTOR_SIZE = 10           // descriptors generated for each post
+)
+
+// Errors returned when a word list cannot be used
+var (
+	ErrUnknownList = errors.New("no word list of that name")
+	ErrEmptyList   = errors.New("word list has no words")
+)
 
-//go:embed adjectives.txt
-var ab []byte
+// A list of words that descriptors are picked from. Words are indexed, so
+// any of them can be found without scanning the list
+type WordList interface {
+	Name() string
+	Len() int
+	Word(index int) string
+}
+
+// A word list parsed once into a slice, one word per line
+type indexedList struct {
+	name  string
+	words []string
+}
 
-/* Generates a descriptors string of the format 'word;word;word;' of length 10,
-to be bound to a post in the database. Gets the length then randomly picks
-words from *adjectives.txt* to add to the string */
-func GenerateDescriptors() (string, error) {
-	var descriptors [10]string
+func (l *indexedList) Name() string          { return l.name }
+func (l *indexedList) Len() int              { return len(l.words) }
+func (l *indexedList) Word(index int) string { return l.words[index] }
 
-	// Generating 10 random words using loop
-	i := 0
-	for i < 10 {
-		descriptors[i] = GenerateDescriptor(WORD_COUNT)
-		i++
+// Every embedded list is named after its file, without the extension
+//
+//go:embed *.txt
+var embedded embed.FS
+
+var (
+	listsMu sync.RWMutex
+	lists   = map[string]WordList{}
+)
+
+/* Parses the embedded lists once, at startup */
+func init() {
+	files, _ := embedded.ReadDir(".")
+	for _, file := range files {
+		data, _ := embedded.ReadFile(file.Name())
+		list, err := ParseList(listName(file.Name()), data)
+		if err != nil {
+			panic("error parsing embedded word list " + file.Name())
+		}
+		Register(list)
 	}
-	return strings.Join(descriptors[:], ";"), nil
 }
 
-/* Generates a single descriptor */
-func GenerateDescriptor(wordCount int) string {
-	rand.Seed(time.Now().UnixNano())
-	index := rand.Intn(wordCount)
-	descriptor, err := parseWord(index)
-	if err != nil {
-		return "error"
+/* Parses a word list with one word on each line. Surrounding space is
+trimmed, and blank lines and lines starting with # are skipped */
+func ParseList(name string, data []byte) (WordList, error) {
+	list := &indexedList{name: name}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word != "" && !strings.HasPrefix(word, "#") {
+			list.words = append(list.words, word)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	} else if len(list.words) == 0 {
+		return nil, ErrEmptyList
 	}
-	return descriptor
+	return list, nil
 }
 
-/* Parses a word at the specificed random index from *adjectives.txt*.
-Does so by scanning lines until the index is reached
-Returns: string; the parsed word */
-func parseWord(index int) (string, error) {
-	scanner := bufio.NewScanner(bytes.NewReader(ab))
-	scanner.Split(bufio.ScanLines)
+/* Registers a word list under its name, replacing any list of that name */
+func Register(list WordList) {
+	listsMu.Lock()
+	defer listsMu.Unlock()
+	lists[list.Name()] = list
+}
 
-	scanCount := 0
-	for scanner.Scan() && scanCount < index {
-		scanner.Bytes()
-		scanCount++
+/* Loads each .txt file in the directory as a word list named after the file,
+so that operators can supply their own lists. Lists of the same name as an
+embedded list replace it. Returns the loaded lists, where an empty directory
+path loads nothing */
+func LoadDir(dir string) ([]WordList, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return nil, err
 	}
-	return scanner.Text(), nil
+	loaded := make([]WordList, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		list, err := ParseList(listName(path), data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		Register(list)
+		loaded = append(loaded, list)
+	}
+	return loaded, nil
+}
+
+/* Finds the word list of the provided name. An empty name finds the list set
+by WORD_LIST, or otherwise the default list */
+func Lookup(name string) (WordList, error) {
+	if name == "" {
+		name = os.Getenv("WORD_LIST")
+	}
+	if name == "" {
+		name = DEFAULT_LIST
+	}
+	listsMu.RLock()
+	defer listsMu.RUnlock()
+	list, ok := lists[name]
+	if !ok {
+		return nil, ErrUnknownList
+	}
+	return list, nil
+}
+
+/* Gets the name of a list from the path of its file */
+func listName(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
 }
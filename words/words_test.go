@@ -1,13 +1,17 @@
 package words
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
 
-/* Tests that the correct descriptors for a post are generated */
+/* Tests that the correct descriptors for a post are generated, with no word
+repeated */
 func TestGenerateDescriptors(t *testing.T) {
-	output, err := GenerateDescriptors()
+	list, _ := Lookup(DEFAULT_LIST)
+	output, err := GenerateDescriptors(list, nil)
 	if err != nil {
 		t.Log("error generating descriptors", err)
 		t.Fail()
@@ -18,31 +22,191 @@ func TestGenerateDescriptors(t *testing.T) {
 		t.Log("descriptors is not 10 words conactenated by ';'")
 		t.Fail()
 	}
+	seen := map[string]bool{}
+	for _, word := range outputs {
+		if seen[word] {
+			t.Logf("descriptor %s repeated", word)
+			t.Fail()
+		}
+		seen[word] = true
+	}
 }
 
-/* Tests that parseWord does what it needs to; the exact functionality works
-for all edge cases */
-func TestParseWord(t *testing.T) {
-	output, err := parseWord(4)
-	if err != nil {
-		t.Log("error when opening the file: ", err)
+/* Tests that a single descriptor is generated from the list, and that a list
+with no allowed words gives an error instead of a descriptor */
+func TestGenerateDescriptor(t *testing.T) {
+	defer SetFilter(DefaultFilter())
+	list, _ := ParseList("test", []byte("calm\nbold"))
+	SetFilter(NewFilter([]string{"bold"}, nil))
+	if word, err := GenerateDescriptor(list); err != nil || word != "bold" {
+		t.Logf("expected the allowed word, found %q with %v", word, err)
+		t.Fail()
+	}
+	SetFilter(NewFilter([]string{"kind"}, nil))
+	if word, err := GenerateDescriptor(list); err != ErrTooFewWords {
+		t.Logf("expected too few words error, found %q with %v", word, err)
+		t.Fail()
+	}
+}
+
+/* Tests that generators with the same seed pick the same words, never
+picking blocked words and avoiding recent words while they can */
+func TestGenerator(t *testing.T) {
+	list, _ := ParseList("test", []byte(
+		"calm\nbold\nbrave\nstupid\nkind\nwise\nwarm\nfair\nkeen\n"+
+			"glad\nneat\nbold\nquiet"))
+	first, _ := NewGenerator(7, DefaultFilter()).Descriptors(list, nil)
+	second, _ := NewGenerator(7, DefaultFilter()).Descriptors(list, nil)
+	if strings.Join(first, ";") != strings.Join(second, ";") {
+		t.Logf("expected equal seeds to match: %v, %v", first, second)
+		t.Fail()
+	}
+
+	// Eleven different words are allowed, so only one recent word is reused
+	generator := NewGenerator(7, DefaultFilter())
+	descriptors, err := generator.Descriptors(list, []string{"Calm", "kind"})
+	reused := 0
+	for _, word := range descriptors {
+		if word == "stupid" {
+			t.Log("expected blocked word to never be picked")
+			t.Fail()
+		} else if word == "calm" || word == "kind" {
+			reused++
+		}
+	}
+	if err != nil || len(descriptors) != DESCRIPTOR_SIZE || reused != 1 {
+		t.Logf("unexpected descriptors %v, %v", descriptors, err)
 		t.Fail()
 	}
 
-	if output != "adventurous" {
-		t.Logf("expecting 'adventurous'. found %s", output)
+	// An allowlist leaves too few words to describe a post
+	allowed := NewGenerator(7, NewFilter([]string{"calm", "bold"}, nil))
+	if _, err = allowed.Descriptors(list, nil); err != ErrTooFewWords {
+		t.Logf("expected too few words error, found %v", err)
+		t.Fail()
+	}
+	if word, _ := allowed.Descriptor(list); word != "calm" && word != "bold" {
+		t.Logf("expected an allowed word, found %s", word)
 		t.Fail()
 	}
+}
 
-	outputZero, _ := parseWord(0)
-	if outputZero != "abandoned" {
-		t.Logf("expecting 'abandoned'. found %s", output)
+/* Tests that words are scored by the cues of the post they share, and by
+agreeing with its sentiment */
+func TestLexicon(t *testing.T) {
+	relevance := DefaultLexicon().Relevance(
+		"Great friends at the office community celebration")
+	cases := []struct {
+		word  string
+		score int
+	}{
+		{"friendly", 3*TOPIC_WEIGHT + 1}, // friend, office, community
+		{"cheerful", TOPIC_WEIGHT + 1},   // celebration and positive
+		{"fatal", -CONFLICT_WEIGHT},      // opposes a positive post
+		{"quiet", 0},                     // neither topical nor polar
+		{"zany", 0},                      // not in the lexicon
+	}
+	for _, test := range cases {
+		if score := relevance.Score(test.word); score != test.score {
+			t.Logf("expected %s to score %d, found %d",
+				test.word, test.score, score)
+			t.Fail()
+		}
+	}
+
+	if _, err := ParseLexicon([]byte("calm\n")); err == nil {
+		t.Log("expected a word without polarity to be refused")
+		t.Fail()
+	}
+	if _, err := ParseLexicon([]byte("calm 3 sea")); err == nil {
+		t.Log("expected polarity out of range to be refused")
+		t.Fail()
+	}
+}
+
+/* Tests that suggestions are mostly relevant to the post, with a few random
+words, and are the same for generators of the same seed */
+func TestSuggest(t *testing.T) {
+	list, _ := Lookup(DEFAULT_LIST)
+	text := "Office community day\nOur friendly neighbours and colleagues " +
+		"came together to celebrate, a wonderful party with cake and music"
+	first, err := NewGenerator(7, DefaultFilter()).Suggest(list, nil, text)
+	second, _ := NewGenerator(7, DefaultFilter()).Suggest(list, nil, text)
+	if err != nil || strings.Join(first, ";") != strings.Join(second, ";") {
+		t.Fatalf("expected equal seeds to match: %v, %v, %v",
+			first, second, err)
+	}
+
+	relevance, relevant := DefaultLexicon().Relevance(text), 0
+	seen := map[string]bool{}
+	for _, word := range first {
+		if seen[word] {
+			t.Logf("descriptor %s repeated", word)
+			t.Fail()
+		} else if relevance.Score(word) < 0 {
+			t.Logf("descriptor %s opposes the post", word)
+			t.Fail()
+		} else if relevance.Score(word) > 0 {
+			relevant++
+		}
+		seen[word] = true
+	}
+	if len(first) != DESCRIPTOR_SIZE || relevant < RELEVANT_SIZE {
+		t.Logf("expected mostly relevant descriptors, found %v", first)
+		t.Fail()
+	}
+
+	// Text with nothing in the lexicon still gets a full set of words
+	if bland, err := NewGenerator(7, DefaultFilter()).Suggest(
+		list, nil, "xyzzy"); err != nil || len(bland) != DESCRIPTOR_SIZE {
+		t.Logf("unexpected descriptors for bland post %v, %v", bland, err)
+		t.Fail()
+	}
+}
+
+/* Tests that the embedded list is indexed once, with its length taken from
+the words it holds */
+func TestEmbeddedList(t *testing.T) {
+	list, err := Lookup("")
+	if err != nil || list.Name() != DEFAULT_LIST {
+		t.Fatalf("unable to find the default list: %v", err)
+	}
+
+	expected := map[int]string{0: "abandoned", 4: "adventurous", 1346: "zany",
+		list.Len() - 1: "zoomy"}
+	for index, word := range expected {
+		if found := list.Word(index); found != word {
+			t.Logf("expecting %q at %d, found %q", word, index, found)
+			t.Fail()
+		}
+	}
+
+	if _, err = Lookup("nonexistent"); err != ErrUnknownList {
+		t.Logf("expected unknown list error, found %v", err)
+		t.Fail()
+	}
+}
+
+/* Tests that operators can supply lists from disk, which skip blank and
+comment lines */
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "birds.txt"),
+		[]byte("# birds of the garden\nrobin\n\n  wren \nthrush"), 0644)
+	if loaded, err := LoadDir(dir); err != nil || len(loaded) != 1 ||
+		loaded[0].Name() != "birds" {
+		t.Fatalf("unable to load list from disk: %v, %v", loaded, err)
+	}
+	list, err := Lookup("birds")
+	if err != nil || list.Len() != 3 || list.Word(1) != "wren" {
+		t.Logf("unexpected list loaded from disk: %+v, %v", list, err)
 		t.Fail()
 	}
 
-	outputEnd, _ := parseWord(1346)
-	if outputEnd != "zany" {
-		t.Logf("expecting 'zany'. found %s", outputEnd)
+	// A list with no words is refused
+	os.WriteFile(filepath.Join(dir, "empty.txt"), []byte("\n# none\n"), 0644)
+	if _, err = LoadDir(dir); err == nil {
+		t.Log("expected an empty list to be refused")
 		t.Fail()
 	}
 }